@@ -1,61 +1,57 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
+	"flag"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agent"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/config"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
 )
 
-func registerUser(username, password, baseURL string) error {
-	client := &http.Client{}
-	data := struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
-	}{
-		Login:    username,
-		Password: password,
-	}
-	jsonData, err := json.Marshal(data)
+func main() {
+	cfg, printConfig, err := config.LoadAgent(os.Args[1:], os.LookupEnv)
 	if err != nil {
-		return err
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-
-	req, err := http.NewRequest("POST", baseURL+"/api/v1/register", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	if printConfig {
+		if err := config.Print(os.Stdout, cfg.Masked()); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+
+	tlsConfig, err := cfg.TLS.ClientConfig()
 	if err != nil {
-		return err
+		log.Fatalf("Invalid TLS configuration: %v", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to register user: %s", resp.Status)
 
-		return nil
+	opts := []agent.Option{
+		agent.WithAgentID(cfg.AgentID),
+		agent.WithOperations(cfg.Operations...),
+		agent.WithComputingPower(cfg.ComputingPower),
+		agent.WithPollWait(time.Duration(cfg.PollWait)),
+		agent.WithPollInterval(time.Duration(cfg.PollInterval)),
+		agent.WithRequestTimeout(time.Duration(cfg.RequestTimeout)),
+		agent.WithShutdownTimeout(time.Duration(cfg.ShutdownTimeout)),
+		agent.WithTLS(tlsConfig),
 	}
-
-	log.Printf("User %s registered successfully", username)
-	return nil
-}
-
-func main() {
-
-	username := "agent"
-	password := "agent_pass"
-	baseURL := "http://localhost:8080"
-
-	if err := registerUser(username, password, baseURL); err != nil {
-		log.Fatalf("Failed to register user: %v", err)
+	if cfg.Transport == "grpc" {
+		opts = append(opts, agent.WithGRPC(cfg.GRPCAddr))
 	}
 
-	ag, err := agent.NewAgent(username, password, baseURL)
+	ag, err := agent.NewAgent(cfg.APIKey, cfg.ServerURL, opts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize agent: %v", err)
 	}
@@ -63,7 +59,12 @@ func main() {
 	if err := ag.Start(); err != nil {
 		log.Fatalf("Failed to start agent: %v", err)
 	}
-	defer ag.Stop()
+	log.Printf("Agent started with %d workers", cfg.ComputingPower)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
 
-	<-make(chan struct{})
+	log.Printf("Received %s, shutting down", sig)
+	ag.Stop()
 }
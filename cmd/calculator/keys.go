@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+)
+
+const keysUsage = `usage: calculator keys [-file path] <command>
+
+commands:
+  list           show keys, the last one signs new tokens
+  generate [-alg HS256|RS256|EdDSA]
+                 add a new key and start signing new tokens with it;
+                 RS256 and EdDSA private keys are written to <kid>.pem next to the key file
+  retire <kid>   remove a key, tokens signed with it stop being accepted
+
+After changing the file send SIGHUP to running orchestrators (or restart them).`
+
+// runKeysCommand управляет файлом ключей подписи токенов. Путь берётся из -file или JWT_KEYS_FILE.
+func runKeysCommand(args []string, lookupEnv func(string) (string, bool), out io.Writer) error {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	defaultPath, _ := lookupEnv("JWT_KEYS_FILE")
+	path := fs.String("file", defaultPath, "key file (env JWT_KEYS_FILE)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), keysUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *path == "" {
+		return errors.New("key file is required: pass -file or set JWT_KEYS_FILE")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("command is required")
+	}
+
+	file, err := auth.ReadKeyFile(*path)
+	if err != nil {
+		return err
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "list":
+		for i, kc := range file.Keys {
+			signing := ""
+			if i == len(file.Keys)-1 {
+				signing = " (signing)"
+			}
+			alg := kc.Algorithm
+			if alg == "" {
+				alg = auth.AlgHS256
+			}
+			fmt.Fprintf(out, "%s\t%s\tcreated %s%s\n", kc.ID, alg, kc.CreatedAt.Format("2006-01-02 15:04:05 MST"), signing)
+		}
+		return nil
+	case "generate":
+		genFlags := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+		alg := genFlags.String("alg", auth.AlgHS256, "signing algorithm: HS256, RS256 or EdDSA")
+		if err := genFlags.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+		kc, err := file.Generate(*alg)
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		if err := file.Write(*path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Generated key %s, it signs new tokens after orchestrators reload keys\n", kc.ID)
+		return nil
+	case "retire":
+		if fs.NArg() != 2 {
+			return errors.New("usage: calculator keys retire <kid>")
+		}
+		kc, err := file.Retire(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		if err := file.Write(*path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Retired key %s\n", kc.ID)
+		if kc.PrivateKeyFile != "" {
+			fmt.Fprintf(out, "Its private key %s is no longer used and can be deleted\n", kc.PrivateKeyFile)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/config"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/orchestrator"
 	_ "github.com/lib/pq"
 
@@ -14,19 +19,53 @@ import (
 )
 
 func main() {
-	connStr := os.Getenv("DB_CONN_STR")
-	if connStr == "" {
-		connStr = "user=postgres dbname=test password=Ebds777staX sslmode=disable"
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeysCommand(os.Args[2:], os.LookupEnv, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	RunMigrations(connStr)
+	cfg, printConfig, err := config.LoadOrchestrator(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout, cfg.Masked()); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	if cfg.Migrations.Auto {
+		RunMigrations(cfg.Database.DSN, cfg.Migrations.Path)
+	}
+
+	server := orchestrator.StartServer(cfg)
+
+	// SIGHUP перечитывает ключи подписи токенов после ротации
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := orchestrator.LoadSigningKeys(cfg); err != nil {
+				log.Printf("Keeping previous signing keys: %v", err)
+			}
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
 
-	server := orchestrator.StartServer(connStr)
-	<-make(chan struct{})
+	log.Printf("Received %s, shutting down", sig)
 	orchestrator.ShutdownServer(server)
 }
 
-func RunMigrations(connStr string) {
+func RunMigrations(connStr, path string) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		log.Fatal("❌ Failed to connect to DB:", err)
@@ -38,7 +77,7 @@ func RunMigrations(connStr string) {
 		log.Fatal("❌ Failed to create driver:", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance("file://migrations", "postgres", driver)
+	m, err := migrate.NewWithDatabaseInstance("file://"+path, "postgres", driver)
 	if err != nil {
 		log.Fatal("❌ Failed to create migrate instance:", err)
 	}
@@ -2,22 +2,164 @@ package agent
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agentpb"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Сколько сервер держит запрос за задачей, если очередь пуста
+	defaultPollWait = 30 * time.Second
+	// Пауза перед повтором после ошибки
+	defaultPollInterval = 5 * time.Second
+	// Таймаут запросов к оркестратору сверх времени long-poll
+	defaultRequestTimeout = 10 * time.Second
+	// Сколько Stop ждёт задачи в работе, прежде чем вернуть их в очередь
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+var (
+	errTaskAborted = errors.New("task aborted by agent shutdown")
+	// задачу нельзя посчитать ни на каком агенте (деление на ноль, неизвестная операция),
+	// возвращать её в очередь бессмысленно
+	errTaskFailed = errors.New("task cannot be computed")
+	// оркестратор останавливается, запрос нужно повторить (балансировщик отправит его на другую реплику)
+	errServerShuttingDown = errors.New("server is shutting down")
+	// токен истёк или отозван, нужно получить новый
+	errUnauthorized = errors.New("unauthorized")
 )
 
 type Agent struct {
-	username string
-	password string
-	token    string
-	baseURL  string // Добавляем базовый URL
+	// API-ключ агента, выданный администратором; обменивается на токен для /internal/*
+	apiKey  string
+	baseURL string // Добавляем базовый URL
+	client  *http.Client
+	tls     *tls.Config
+
+	// токен общий для всех воркеров; tokenRefreshAt — когда обновить его, не дожидаясь 401
+	tokenMu        sync.RWMutex
+	token          string
+	tokenRefreshAt time.Time
+	authMu         sync.Mutex
+	clock          Clock
+
+	// под этим идентификатором агент записан в реестре оркестратора
+	id         string
+	hostname   string
+	operations []string
+
+	computingPower  int
+	pollWait        time.Duration
+	pollInterval    time.Duration
+	requestTimeout  time.Duration
+	shutdownTimeout time.Duration
+	// ctx отменяется в Stop: воркеры больше не берут задачи.
+	// abortCtx отменяется, если задачи в работе не успели за shutdownTimeout.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	abortCtx context.Context
+	abort    context.CancelFunc
+	wg       sync.WaitGroup
+
+	grpcAddr     string
+	grpcDialOpts []grpc.DialOption
+	grpcConn     *grpc.ClientConn
+	grpcClient   agentpb.AgentServiceClient
+}
+
+type Option func(*Agent)
+
+// WithGRPC переключает получение задач и отправку результатов на gRPC по адресу addr.
+// Логин по-прежнему идёт через HTTP API.
+func WithGRPC(addr string) Option {
+	return func(a *Agent) {
+		a.grpcAddr = addr
+	}
+}
+
+// WithGRPCDialOptions добавляет опции подключения к gRPC, например свой dialer
+func WithGRPCDialOptions(opts ...grpc.DialOption) Option {
+	return func(a *Agent) {
+		a.grpcDialOpts = append(a.grpcDialOpts, opts...)
+	}
+}
+
+// WithAgentID задаёт идентификатор агента в реестре оркестратора.
+// По умолчанию при каждом запуске генерируется случайный.
+func WithAgentID(id string) Option {
+	return func(a *Agent) {
+		a.id = id
+	}
+}
+
+// WithOperations задаёт операции, задачи с которыми агент готов вычислять.
+// Оркестратор не выдаёт агенту задачи с другими операциями.
+func WithOperations(ops ...string) Option {
+	return func(a *Agent) {
+		a.operations = ops
+	}
+}
+
+// WithComputingPower задаёт количество воркеров, одновременно вычисляющих задачи.
+func WithComputingPower(n int) Option {
+	return func(a *Agent) {
+		a.computingPower = n
+	}
+}
+
+// WithShutdownTimeout задаёт, сколько Stop ждёт задачи в работе.
+// Недосчитанные к этому времени задачи возвращаются в очередь.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(a *Agent) {
+		a.shutdownTimeout = d
+	}
+}
+
+// WithTLS задаёт настройки TLS для HTTP, WebSocket и gRPC соединений с оркестратором.
+func WithTLS(cfg *tls.Config) Option {
+	return func(a *Agent) {
+		a.tls = cfg
+	}
+}
+
+// WithPollWait задаёт, сколько сервер держит запрос за задачей при пустой очереди.
+func WithPollWait(d time.Duration) Option {
+	return func(a *Agent) {
+		a.pollWait = d
+	}
+}
+
+// WithPollInterval задаёт паузу перед повтором после ошибки.
+func WithPollInterval(d time.Duration) Option {
+	return func(a *Agent) {
+		a.pollInterval = d
+	}
+}
+
+// WithRequestTimeout задаёт таймаут запросов к оркестратору (к long-poll добавляется время ожидания).
+func WithRequestTimeout(d time.Duration) Option {
+	return func(a *Agent) {
+		a.requestTimeout = d
+	}
 }
 
 type Task struct {
@@ -32,42 +174,96 @@ type Task struct {
 	DependsOn     []string `json:"depends_on"`
 }
 
+// Clock — часы, по которым агент планирует обновление токена
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock подменяет часы, по которым агент обновляет токен, например в тестах.
+func WithClock(c Clock) Option {
+	return func(a *Agent) {
+		a.clock = c
+	}
+}
+
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
 }
 
-func NewAgent(username, password, baseURL string) (*Agent, error) {
+func NewAgent(apiKey, baseURL string, opts ...Option) (*Agent, error) {
+	if apiKey == "" {
+		return nil, errors.New("agent API key is required")
+	}
 	if baseURL == "" {
 		baseURL = "http://localhost:8080"
 	}
-	return &Agent{
-		username: username,
-		password: password,
-		baseURL:  baseURL,
-	}, nil
-}
-
-func (a *Agent) authenticate() error {
-	client := &http.Client{}
-	data := struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
-	}{
-		Login:    a.username,
-		Password: a.password,
+	a := &Agent{
+		apiKey:          apiKey,
+		baseURL:         baseURL,
+		operations:      []string{"+", "-", "*", "/"},
+		computingPower:  1,
+		pollWait:        defaultPollWait,
+		pollInterval:    defaultPollInterval,
+		requestTimeout:  defaultRequestTimeout,
+		shutdownTimeout: defaultShutdownTimeout,
+		clock:           systemClock{},
 	}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal login data: %w", err)
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.computingPower < 1 {
+		a.computingPower = 1
+	}
+	if a.id == "" {
+		a.id = uuid.NewString()
+	}
+	a.hostname, _ = os.Hostname()
+
+	// один клиент на все запросы, чтобы переиспользовать соединения
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = a.tls
+	a.client = &http.Client{Timeout: a.pollWait + a.requestTimeout, Transport: transport}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	a.abortCtx, a.abort = context.WithCancel(context.Background())
+
+	if a.grpcAddr != "" {
+		creds := insecure.NewCredentials()
+		if a.tls != nil {
+			creds = credentials.NewTLS(a.tls)
+		}
+		dialOpts := append([]grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:                30 * time.Second,
+				Timeout:             10 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		}, a.grpcDialOpts...)
+		conn, err := grpc.NewClient(a.grpcAddr, dialOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+		}
+		a.grpcConn = conn
+		a.grpcClient = agentpb.NewAgentServiceClient(conn)
 	}
 
-	req, err := http.NewRequest("POST", a.baseURL+"/api/v1/login", bytes.NewBuffer(jsonData))
+	return a, nil
+}
+
+func (a *Agent) authenticate() error {
+	req, err := a.loginRequest()
 	if err != nil {
-		return fmt.Errorf("failed to create login request: %w", err)
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send login request: %w", err)
 	}
@@ -83,29 +279,76 @@ func (a *Agent) authenticate() error {
 		return fmt.Errorf("failed to decode login response: %w", err)
 	}
 
+	a.tokenMu.Lock()
 	a.token = loginResp.Token
-	log.Printf("Successfully authenticated, new token: %s", a.token)
+	a.tokenRefreshAt = time.Time{}
+	if loginResp.ExpiresIn > 0 {
+		// обновляем заранее, на 80% срока жизни токена
+		a.tokenRefreshAt = a.clock.Now().Add(time.Duration(loginResp.ExpiresIn) * time.Second * 4 / 5)
+	}
+	a.tokenMu.Unlock()
+	logging.Infof("Successfully authenticated")
 	return nil
 }
 
+func (a *Agent) loginRequest() (*http.Request, error) {
+	req, err := http.NewRequest("POST", a.baseURL+"/internal/agent/token", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Authorization", "ApiKey "+a.apiKey)
+	return req, nil
+}
+
+func (a *Agent) currentToken() string {
+	a.tokenMu.RLock()
+	defer a.tokenMu.RUnlock()
+	return a.token
+}
+
+// reauthenticate получает новый токен взамен stale. Если другой воркер уже
+// обновил токен, пока мы ждали, повторный логин не нужен.
+func (a *Agent) reauthenticate(stale string) error {
+	a.authMu.Lock()
+	defer a.authMu.Unlock()
+
+	if a.currentToken() != stale {
+		return nil
+	}
+	return a.authenticate()
+}
+
 func (a *Agent) getTask() (*Task, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", a.baseURL+"/internal/task", nil)
+	if a.grpcClient != nil {
+		return a.getTaskGRPC()
+	}
+
+	url := fmt.Sprintf("%s/internal/task?wait=%s", a.baseURL, a.pollWait)
+	req, err := http.NewRequestWithContext(a.ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+a.token)
+	a.setHeaders(req.Header)
 
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errAgentNotRegistered
+	}
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, errServerShuttingDown
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status: %s, body: %s", resp.Status, string(body))
+		return nil, unexpectedStatus(resp)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -122,104 +365,165 @@ func (a *Agent) getTask() (*Task, error) {
 }
 
 func (a *Agent) processTask(task *Task) error {
-	log.Printf("Processing task %s: %s %s %s", task.ID, task.Arg1, task.Operation, task.Arg2)
+	result, err := a.computeTask(task)
+	if errors.Is(err, errTaskAborted) {
+		if err := a.returnTask(task.ID); err != nil {
+			return fmt.Errorf("failed to return task: %w", err)
+		}
+		logging.Infof("Returned task %s to queue", task.ID)
+		return nil
+	}
+	if errors.Is(err, errTaskFailed) {
+		logging.Errorf("Task %s failed: %v", task.ID, err)
+		if err := a.failTask(task.ID); err != nil {
+			return fmt.Errorf("failed to report task failure: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		// временная ошибка: отдаём задачу обратно, иначе она зависнет в работе
+		if err := a.returnTask(task.ID); err != nil {
+			logging.Errorf("Failed to return task %s: %v", task.ID, err)
+		}
+		return err
+	}
+
+	if err := a.submitResult(task.ID, result); err != nil {
+		return fmt.Errorf("failed to submit result: %w", err)
+	}
+	logging.Debugf("Successfully submitted result for task %s: %.2f", task.ID, result)
 
-	arg1Value := a.getArgValue(task.Arg1)
-	if arg1Value == -1 {
-		return fmt.Errorf("failed to get value for Arg1: %s", task.Arg1)
+	return nil
+}
+
+func (a *Agent) computeTask(task *Task) (float64, error) {
+	logging.Debugf("Processing task %s: %s %s %s", task.ID, task.Arg1, task.Operation, task.Arg2)
+
+	arg1Value, err := a.getArgValue(task.Arg1)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get value for Arg1: %w", err)
 	}
-	log.Printf("Arg1 value for task %s: %.2f", task.ID, arg1Value)
+	logging.Debugf("Arg1 value for task %s: %.2f", task.ID, arg1Value)
 
-	arg2Value := a.getArgValue(task.Arg2)
-	if arg2Value == -1 {
-		return fmt.Errorf("failed to get value for Arg2: %s", task.Arg2)
+	arg2Value, err := a.getArgValue(task.Arg2)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get value for Arg2: %w", err)
 	}
-	log.Printf("Arg2 value for task %s: %.2f", task.ID, arg2Value)
+	logging.Debugf("Arg2 value for task %s: %.2f", task.ID, arg2Value)
 
 	var result float64
 	switch task.Operation {
 	case "+":
 		result = arg1Value + arg2Value
+	case "-":
+		result = arg1Value - arg2Value
 	case "*":
 		result = arg1Value * arg2Value
+	case "/":
+		if arg2Value == 0 {
+			return 0, fmt.Errorf("%w: division by zero", errTaskFailed)
+		}
+		result = arg1Value / arg2Value
 	default:
-		return fmt.Errorf("unsupported operation: %s", task.Operation)
+		return 0, fmt.Errorf("%w: unsupported operation %s", errTaskFailed, task.Operation)
 	}
-	log.Printf("Computed result for task %s: %.2f", task.ID, result)
-
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+	logging.Debugf("Computed result for task %s: %.2f", task.ID, result)
 
-	if err := a.submitResult(task.ID, result); err != nil {
-		return fmt.Errorf("failed to submit result: %w", err)
+	select {
+	case <-time.After(time.Duration(task.OperationTime) * time.Millisecond):
+	case <-a.abortCtx.Done():
+		return 0, errTaskAborted
 	}
-	log.Printf("Successfully submitted result for task %s: %.2f", task.ID, result)
 
-	return nil
+	return result, nil
 }
 
-func (a *Agent) getArgValue(arg string) float64 {
+// getArgValue возвращает значение аргумента: число или результат задачи, от которой зависит текущая.
+// Ошибка получения результата временная, нечисловой аргумент — errTaskFailed.
+func (a *Agent) getArgValue(arg string) (float64, error) {
 	uuidRegex := regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
 	if uuidRegex.MatchString(arg) {
-		log.Printf("Arg %s is a task ID, fetching result", arg)
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/internal/task/%s", a.baseURL, arg), nil)
-		if err != nil {
-			log.Printf("Failed to create request for task %s: %v", arg, err)
-			return -1
+		logging.Debugf("Arg %s is a task ID, fetching result", arg)
+
+		var task *Task
+		var err error
+		if a.grpcClient != nil {
+			task, err = a.fetchTaskGRPC(arg)
+		} else {
+			task, err = a.fetchTask(arg)
 		}
-		req.Header.Set("Authorization", "Bearer "+a.token)
-
-		resp, err := client.Do(req)
 		if err != nil {
-			log.Printf("Failed to send request for task %s: %v", arg, err)
-			return -1
+			return 0, fmt.Errorf("failed to fetch task %s: %w", arg, err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("Unexpected status for task %s: %s, body: %s", arg, resp.Status, string(body))
-			return -1
+		if task.Result == nil || task.Status != "completed" {
+			return 0, fmt.Errorf("task %s not completed or result unavailable", arg)
 		}
 
-		var task Task
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Failed to read response for task %s: %v", arg, err)
-			return -1
-		}
+		logging.Debugf("Fetched result for task %s: %.2f", arg, *task.Result)
+		return *task.Result, nil
+	}
 
-		if err := json.Unmarshal(body, &task); err != nil {
-			log.Printf("Failed to unmarshal response for task %s: %v", arg, err)
-			return -1
-		}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid argument value %q", errTaskFailed, arg)
+	}
+	return value, nil
+}
 
-		if task.Result == nil || task.Status != "completed" {
-			log.Printf("Task %s not completed or result unavailable", arg)
-			return -1
-		}
+func (a *Agent) fetchTask(taskID string) (*Task, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/internal/task/%s", a.baseURL, taskID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	a.setHeaders(req.Header)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
 
-		log.Printf("Fetched result for task %s: %.2f", arg, *task.Result)
-		return *task.Result
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedStatus(resp)
 	}
 
-	if value, err := strconv.ParseFloat(arg, 64); err == nil {
-		return value
+	var task Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal task: %w", err)
 	}
 
-	log.Printf("Invalid argument value: %s", arg)
-	return -1
+	return &task, nil
 }
 
 func (a *Agent) submitResult(taskID string, result float64) error {
+	logging.Debugf("Submitting result for task %s: %.2f", taskID, result)
+	return a.sendTaskStatus(taskID, "completed", &result)
+}
+
+// returnTask возвращает задачу в очередь, чтобы её досчитал другой агент
+func (a *Agent) returnTask(taskID string) error {
+	return a.sendTaskStatus(taskID, "pending", nil)
+}
+
+// failTask сообщает, что задачу посчитать нельзя: выражение получит статус failed
+func (a *Agent) failTask(taskID string) error {
+	return a.sendTaskStatus(taskID, "failed", nil)
+}
+
+func (a *Agent) sendTaskStatus(taskID, status string, result *float64) error {
+	if a.grpcClient != nil {
+		return a.sendTaskStatusGRPC(taskID, status, result)
+	}
+
 	data := struct {
-		ID     string  `json:"id"`
-		Result float64 `json:"result"`
-		Status string  `json:"status"`
+		ID     string   `json:"id"`
+		Result *float64 `json:"result,omitempty"`
+		Status string   `json:"status"`
 	}{
 		ID:     taskID,
 		Result: result,
-		Status: "completed",
+		Status: status,
 	}
 
 	jsonData, err := json.Marshal(data)
@@ -227,25 +531,22 @@ func (a *Agent) submitResult(taskID string, result float64) error {
 		return fmt.Errorf("failed to marshal result: %w", err)
 	}
 
-	client := &http.Client{}
 	req, err := http.NewRequest("POST", a.baseURL+"/internal/task/requeue", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+a.token)
+	a.setHeaders(req.Header)
 
-	log.Printf("Submitting result for task %s: %.2f", taskID, result)
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status: %s, body: %s", resp.Status, string(body))
+		return unexpectedStatus(resp)
 	}
 
 	return nil
@@ -255,55 +556,197 @@ func (a *Agent) Start() error {
 	if err := a.authenticate(); err != nil {
 		return fmt.Errorf("authentication failed: %w", err)
 	}
+	if err := a.register(); err != nil {
+		return fmt.Errorf("registration failed: %w", err)
+	}
 
+	a.wg.Add(3)
+	go func() {
+		defer a.wg.Done()
+		a.run()
+	}()
+	go func() {
+		defer a.wg.Done()
+		a.runHeartbeat()
+	}()
 	go func() {
-		for {
-			task, err := a.getTask()
-			if err != nil {
-				log.Printf("Error getting task: %v", err)
-				if isUnauthorized(err) {
-					if err := a.authenticate(); err != nil {
-						log.Printf("Failed to re-authenticate: %v", err)
-						time.Sleep(5 * time.Second)
-						continue
-					}
-					continue
+		defer a.wg.Done()
+		a.runTokenRefresh()
+	}()
+
+	return nil
+}
+
+func (a *Agent) run() {
+	for a.ctx.Err() == nil {
+		token := a.currentToken()
+		var err error
+		if a.grpcClient != nil {
+			err = a.runGRPCStream()
+		} else {
+			err = a.runStream()
+		}
+		if errors.Is(err, errStreamUnsupported) {
+			logging.Warnf("Server does not support task streaming, falling back to polling")
+			a.pollTasks()
+			return
+		}
+		if a.ctx.Err() != nil {
+			return
+		}
+
+		logging.Warnf("Task stream closed: %v", err)
+		if a.handleNotRegistered(err) {
+			continue
+		}
+		if isUnauthorized(err) {
+			if err := a.reauthenticate(token); err != nil {
+				logging.Errorf("Failed to re-authenticate: %v", err)
+			}
+		}
+		a.sleep(a.pollInterval)
+	}
+}
+
+// pollTasks запускает computingPower воркеров, каждый из которых сам забирает задачи
+// через GET /internal/task. Возвращается после Stop, когда все воркеры закончили текущие задачи.
+func (a *Agent) pollTasks() {
+	var wg sync.WaitGroup
+	for i := 0; i < a.computingPower; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			a.pollWorker(worker)
+		}(i + 1)
+	}
+	wg.Wait()
+}
+
+func (a *Agent) pollWorker(worker int) {
+	for a.ctx.Err() == nil {
+		token := a.currentToken()
+		task, err := a.getTask()
+		if err != nil {
+			if a.ctx.Err() != nil {
+				return
+			}
+			logging.Errorf("Worker %d: error getting task: %v", worker, err)
+			if errors.Is(err, errServerShuttingDown) {
+				a.sleep(time.Second)
+				continue
+			}
+			if a.handleNotRegistered(err) {
+				continue
+			}
+			if isUnauthorized(err) {
+				if err := a.reauthenticate(token); err != nil {
+					logging.Errorf("Failed to re-authenticate: %v", err)
+					a.sleep(a.pollInterval)
 				}
-				time.Sleep(5 * time.Second)
 				continue
 			}
-			if task != nil {
-				log.Printf("Received task: %+v", task)
-				if err := a.processTask(task); err != nil {
-					log.Printf("Error processing task: %v", err)
-					if isUnauthorized(err) {
-						if err := a.authenticate(); err != nil {
-							log.Printf("Failed to re-authenticate: %v", err)
-							time.Sleep(5 * time.Second)
-							continue
-						}
-						continue
-					}
-					time.Sleep(5 * time.Second)
-					continue
+			a.sleep(a.pollInterval)
+			continue
+		}
+		if task == nil {
+			// сервер уже подождал taskPollWait, сразу идём за следующей задачей
+			logging.Debugf("Worker %d: no tasks available", worker)
+			continue
+		}
+
+		// полученную задачу доводим до конца даже после Stop, иначе она зависнет в работе
+		logging.Debugf("Worker %d: received task: %+v", worker, task)
+		if err := a.processTask(task); err != nil {
+			logging.Errorf("Worker %d: error processing task: %v", worker, err)
+			if isUnauthorized(err) {
+				if err := a.reauthenticate(token); err != nil {
+					logging.Errorf("Failed to re-authenticate: %v", err)
+					a.sleep(a.pollInterval)
 				}
-			} else {
-				log.Println("No tasks available")
-				time.Sleep(5 * time.Second)
+				continue
 			}
+			a.sleep(a.pollInterval)
 		}
-	}()
+	}
+}
 
-	return nil
+// sleep ждёт d или остановки агента
+func (a *Agent) sleep(d time.Duration) {
+	select {
+	case <-a.ctx.Done():
+	case <-time.After(d):
+	}
 }
 
+// Stop перестаёт брать новые задачи и ждёт не дольше shutdownTimeout, пока воркеры
+// досчитают уже полученные. Задачи, которые не начаты или не успели досчитаться,
+// возвращаются в очередь со статусом pending.
 func (a *Agent) Stop() {
-	log.Println("Agent stopping")
+	logging.Infof("Agent stopping")
+	a.cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(a.shutdownTimeout):
+		logging.Warnf("Tasks still in progress after %s, returning them to queue", a.shutdownTimeout)
+		a.abort()
+		<-finished
+	}
+	a.abort()
+
+	if a.grpcConn != nil {
+		a.grpcConn.Close()
+	}
+	logging.Infof("Agent stopped")
 }
 
-func isUnauthorized(err error) bool {
-	if err == nil {
-		return false
+// runTokenRefresh обновляет токен до истечения, чтобы запросы воркеров не получали 401
+func (a *Agent) runTokenRefresh() {
+	for {
+		a.tokenMu.RLock()
+		token, refreshAt := a.token, a.tokenRefreshAt
+		a.tokenMu.RUnlock()
+
+		// сервер не сообщил срок жизни токена — его обновит первый же 401
+		wait := time.Minute
+		if !refreshAt.IsZero() {
+			wait = refreshAt.Sub(a.clock.Now())
+			if wait <= 0 {
+				// прошлая попытка не удалась, повторяем не чаще pollInterval
+				wait = a.pollInterval
+			}
+		}
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.clock.After(wait):
+		}
+
+		if refreshAt.IsZero() {
+			continue
+		}
+		// если токен тем временем обновил воркер, reauthenticate ничего не сделает
+		if err := a.reauthenticate(token); err != nil {
+			logging.Errorf("Failed to refresh token: %v", err)
+		}
 	}
-	return strings.Contains(err.Error(), "401 Unauthorized")
+}
+
+// unexpectedStatus собирает ошибку из ответа с неожиданным статусом
+func unexpectedStatus(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("unexpected status: %s, body: %s", resp.Status, string(body))
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %w", errUnauthorized, err)
+	}
+	return err
+}
+
+func isUnauthorized(err error) bool {
+	return errors.Is(err, errUnauthorized) || status.Code(err) == codes.Unauthenticated
 }
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agentpb"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func (a *Agent) authContext(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+a.currentToken(), "x-agent-id", a.id)
+}
+
+// runGRPCStream — то же, что runStream, но через AgentService.StreamTasks
+func (a *Agent) runGRPCStream() error {
+	ctx, cancel := context.WithCancel(a.authContext(context.Background()))
+	defer cancel()
+
+	stream, err := a.grpcClient.StreamTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open task stream: %w", err)
+	}
+
+	var sendMu sync.Mutex
+	send := func(msg streamMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(toAgentMessage(msg))
+	}
+
+	if err := send(streamMessage{Type: "hello", Concurrency: a.computingPower}); err != nil {
+		// причину обрыва стрима сообщает Recv
+		if _, recvErr := stream.Recv(); status.Code(recvErr) == codes.Unimplemented {
+			return errStreamUnsupported
+		}
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	logging.Infof("Connected to gRPC task stream at %s", a.grpcAddr)
+
+	return a.serveStream(func() (*Task, error) {
+		assignment, err := stream.Recv()
+		if status.Code(err) == codes.Unimplemented {
+			return nil, errStreamUnsupported
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from task stream: %w", err)
+		}
+		return fromProtoTask(assignment.Task), nil
+	}, send)
+}
+
+func (a *Agent) fetchTaskGRPC(taskID string) (*Task, error) {
+	resp, err := a.grpcClient.GetTask(a.authContext(context.Background()), &agentpb.GetTaskRequest{TaskId: taskID})
+	if err != nil {
+		return nil, err
+	}
+	return fromProtoTask(resp.Task), nil
+}
+
+// getTaskGRPC — то же, что getTask, но через GetTask: ждёт задачу до pollWait
+func (a *Agent) getTaskGRPC() (*Task, error) {
+	ctx, cancel := context.WithTimeout(a.authContext(a.ctx), a.pollWait+a.requestTimeout)
+	defer cancel()
+
+	resp, err := a.grpcClient.GetTask(ctx, &agentpb.GetTaskRequest{WaitMs: a.pollWait.Milliseconds()})
+	switch status.Code(err) {
+	case codes.OK:
+		return fromProtoTask(resp.Task), nil
+	case codes.NotFound:
+		return nil, nil
+	case codes.Unavailable:
+		return nil, fmt.Errorf("%w: %w", errServerShuttingDown, err)
+	default:
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+}
+
+func (a *Agent) sendTaskStatusGRPC(taskID, taskStatus string, result *float64) error {
+	ctx, cancel := context.WithTimeout(a.authContext(context.Background()), a.requestTimeout)
+	defer cancel()
+
+	_, err := a.grpcClient.SubmitResult(ctx, &agentpb.SubmitResultRequest{TaskId: taskID, Status: taskStatus, Result: result})
+	return err
+}
+
+func (a *Agent) heartbeatGRPC() error {
+	ctx, cancel := context.WithTimeout(a.authContext(a.ctx), a.requestTimeout)
+	defer cancel()
+
+	_, err := a.grpcClient.Heartbeat(ctx, &agentpb.HeartbeatRequest{})
+	return err
+}
+
+func toAgentMessage(msg streamMessage) *agentpb.AgentMessage {
+	switch msg.Type {
+	case "hello":
+		return &agentpb.AgentMessage{Payload: &agentpb.AgentMessage_Hello{
+			Hello: &agentpb.Hello{Concurrency: int32(msg.Concurrency)},
+		}}
+	case "result":
+		return &agentpb.AgentMessage{Payload: &agentpb.AgentMessage_Result{
+			Result: &agentpb.SubmitResultRequest{TaskId: msg.TaskID, Status: msg.Status, Result: msg.Result},
+		}}
+	default:
+		return &agentpb.AgentMessage{Payload: &agentpb.AgentMessage_Heartbeat{
+			Heartbeat: &agentpb.HeartbeatRequest{},
+		}}
+	}
+}
+
+func fromProtoTask(t *agentpb.Task) *Task {
+	return &Task{
+		ID:            t.GetId(),
+		ExpressionID:  int(t.GetExpressionId()),
+		Arg1:          t.GetArg1(),
+		Arg2:          t.GetArg2(),
+		Operation:     t.GetOperation(),
+		OperationTime: int(t.GetOperationTime()),
+		Status:        t.GetStatus(),
+		Result:        t.Result,
+		DependsOn:     t.GetDependsOn(),
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Version сообщается оркестратору при регистрации; задаётся при сборке через -ldflags "-X ...agent.Version=..."
+var Version = "dev"
+
+// Агент подтверждает, что жив, чаще, чем оркестратор считает его отключённым
+const heartbeatInterval = 15 * time.Second
+
+// оркестратор не знает агента (например, реестр очистили) — нужно зарегистрироваться заново
+var errAgentNotRegistered = errors.New("agent is not registered")
+
+// setHeaders добавляет в запрос к /internal/* токен и идентификатор агента
+func (a *Agent) setHeaders(h http.Header) {
+	h.Set("Authorization", "Bearer "+a.currentToken())
+	h.Set("X-Agent-ID", a.id)
+}
+
+// register сообщает оркестратору идентификатор агента, поддерживаемые операции и число воркеров.
+// Повторная регистрация обновляет данные агента.
+func (a *Agent) register() error {
+	data := struct {
+		ID          string   `json:"id"`
+		Hostname    string   `json:"hostname"`
+		Version     string   `json:"version"`
+		Operations  []string `json:"operations"`
+		Concurrency int      `json:"concurrency"`
+	}{
+		ID:          a.id,
+		Hostname:    a.hostname,
+		Version:     Version,
+		Operations:  a.operations,
+		Concurrency: a.computingPower,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal registration: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", a.baseURL+"/internal/agents", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	a.setHeaders(req.Header)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp)
+	}
+
+	logging.Infof("Registered as agent %s with operations %v", a.id, a.operations)
+	return nil
+}
+
+func (a *Agent) heartbeat() error {
+	if a.grpcClient != nil {
+		return a.heartbeatGRPC()
+	}
+
+	req, err := http.NewRequestWithContext(a.ctx, "POST", a.baseURL+"/internal/agents/"+a.id+"/heartbeat", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	a.setHeaders(req.Header)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errAgentNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp)
+	}
+	return nil
+}
+
+// runHeartbeat раз в heartbeatInterval отмечает агента живым, пока агент не остановлен
+func (a *Agent) runHeartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		token := a.currentToken()
+		err := a.heartbeat()
+		if err == nil || a.ctx.Err() != nil {
+			continue
+		}
+		logging.Warnf("Heartbeat failed: %v", err)
+		if isUnauthorized(err) {
+			if err := a.reauthenticate(token); err != nil {
+				logging.Errorf("Failed to re-authenticate: %v", err)
+			}
+		} else {
+			a.handleNotRegistered(err)
+		}
+	}
+}
+
+// handleNotRegistered регистрирует агента заново, если оркестратор его не знает
+func (a *Agent) handleNotRegistered(err error) bool {
+	if !errors.Is(err, errAgentNotRegistered) && status.Code(err) != codes.FailedPrecondition {
+		return false
+	}
+	if err := a.register(); err != nil {
+		logging.Errorf("Failed to register agent: %v", err)
+	}
+	return true
+}
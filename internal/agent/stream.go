@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
+)
+
+const (
+	streamHeartbeatInterval = 10 * time.Second
+	streamWriteTimeout      = 10 * time.Second
+)
+
+var errStreamUnsupported = errors.New("task streaming is not supported by server")
+
+type streamMessage struct {
+	Type        string   `json:"type"`
+	Concurrency int      `json:"concurrency,omitempty"`
+	Task        *Task    `json:"task,omitempty"`
+	TaskID      string   `json:"task_id,omitempty"`
+	Result      *float64 `json:"result,omitempty"`
+	Status      string   `json:"status,omitempty"`
+}
+
+// runStream держит соединение /internal/agent/stream: сервер присылает задачи,
+// агент отвечает результатами и heartbeat. Возвращается, когда соединение оборвалось.
+func (a *Agent) runStream() error {
+	wsURL := "ws" + strings.TrimPrefix(a.baseURL, "http") + "/internal/agent/stream"
+	header := http.Header{}
+	a.setHeaders(header)
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = a.tls
+	conn, resp, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		if resp != nil {
+			if resp.StatusCode == http.StatusNotFound {
+				return errStreamUnsupported
+			}
+			if resp.StatusCode == http.StatusConflict {
+				return errAgentNotRegistered
+			}
+			if resp.StatusCode == http.StatusUnauthorized {
+				return errUnauthorized
+			}
+			return fmt.Errorf("unexpected handshake status: %s", resp.Status)
+		}
+		return fmt.Errorf("failed to connect to task stream: %w", err)
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(msg streamMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+
+	if err := send(streamMessage{Type: "hello", Concurrency: a.computingPower}); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	logging.Infof("Connected to task stream")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(streamHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := send(streamMessage{Type: "heartbeat"}); err != nil {
+					logging.Errorf("Failed to send heartbeat: %v", err)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return a.serveStream(func() (*Task, error) {
+		for {
+			var msg streamMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return nil, fmt.Errorf("failed to read from task stream: %w", err)
+			}
+			if msg.Type == "task" && msg.Task != nil {
+				return msg.Task, nil
+			}
+			logging.Warnf("Unexpected task stream message: %+v", msg)
+		}
+	}, send)
+}
+
+// serveStream получает задачи через recv и раздаёт их computingPower воркерам, отправляя ответы через send.
+// Возвращается с ошибкой recv или после Stop, дождавшись задач, которые уже в работе.
+func (a *Agent) serveStream(recv func() (*Task, error), send func(streamMessage) error) error {
+	// сервер не присылает больше задач, чем объявлено в hello, так что буфера хватает
+	tasks := make(chan *Task, a.computingPower)
+	var workers sync.WaitGroup
+	for i := 0; i < a.computingPower; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for task := range tasks {
+				if a.ctx.Err() != nil {
+					a.returnStreamTask(task, send)
+					continue
+				}
+				a.handleStreamTask(task, send)
+			}
+		}()
+	}
+	defer func() {
+		close(tasks)
+		workers.Wait()
+	}()
+
+	type received struct {
+		task *Task
+		err  error
+	}
+	incoming := make(chan received)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			task, err := recv()
+			select {
+			case incoming <- received{task, err}:
+			case <-done:
+				// соединение закрывается, задачу, пришедшую в последний момент, отдаём обратно
+				if task != nil {
+					a.returnStreamTask(task, send)
+				}
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return a.ctx.Err()
+		case r := <-incoming:
+			if r.err != nil {
+				return r.err
+			}
+			tasks <- r.task
+		}
+	}
+}
+
+// returnStreamTask возвращает задачу в очередь, не вычисляя её
+func (a *Agent) returnStreamTask(task *Task, send func(streamMessage) error) {
+	if err := send(streamMessage{Type: "result", TaskID: task.ID, Status: "pending"}); err != nil {
+		logging.Errorf("Failed to return task %s: %v", task.ID, err)
+		return
+	}
+	logging.Infof("Returned task %s to queue", task.ID)
+}
+
+func (a *Agent) handleStreamTask(task *Task, send func(streamMessage) error) {
+	logging.Debugf("Received task: %+v", task)
+
+	msg := streamMessage{Type: "result", TaskID: task.ID}
+	result, err := a.computeTask(task)
+	if errors.Is(err, errTaskAborted) {
+		msg.Status = "pending"
+	} else if errors.Is(err, errTaskFailed) {
+		logging.Errorf("Task %s failed: %v", task.ID, err)
+		msg.Status = "failed"
+	} else if err != nil {
+		// временная ошибка: отдаём задачу обратно в очередь, но не сразу, чтобы не крутить её впустую
+		logging.Errorf("Error processing task: %v", err)
+		a.sleep(a.pollInterval)
+		msg.Status = "pending"
+	} else {
+		msg.Status = "completed"
+		msg.Result = &result
+	}
+
+	if err := send(msg); err != nil {
+		logging.Errorf("Failed to send result for task %s: %v", task.ID, err)
+		return
+	}
+	logging.Debugf("Sent %s status for task %s", msg.Status, task.ID)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Префиксы ключей и refresh-токенов: по ним ключ легко узнать в логах и конфигурации
+const (
+	AgentKeyPrefix     = "calc_agent_"
+	UserKeyPrefix      = "calc_user_"
+	RefreshTokenPrefix = "calc_rt_"
+)
+
+// Права личного API-ключа: read — только чтение (GET), submit — ещё и отправка выражений
+const (
+	ScopeRead   = "read"
+	ScopeSubmit = "submit"
+)
+
+func IsScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeSubmit
+}
+
+// Сколько первых символов ключа хранится открыто, чтобы администратор мог отличить ключи
+const apiKeyDisplayLength = 16
+
+// NewAPIKey генерирует ключ вида <prefix><64 hex-символа>
+func NewAPIKey(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// HashAPIKey возвращает хэш, под которым ключ хранится в БД.
+// У ключа 256 бит энтропии, поэтому медленный хэш вроде bcrypt не нужен.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyDisplayPrefix — начало ключа, которое показывается в списках ключей
+func APIKeyDisplayPrefix(key string) string {
+	return key[:min(len(key), apiKeyDisplayLength)]
+}
+
+// APIKeyFromHeader достаёт ключ из заголовка "Authorization: ApiKey <ключ>"
+func APIKeyFromHeader(header string) (string, bool) {
+	key, ok := strings.CutPrefix(header, "ApiKey ")
+	key = strings.TrimSpace(key)
+	return key, ok && key != ""
+}
@@ -1,46 +1,108 @@
 package auth
 
 import (
-	"fmt"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 const (
-	TokenExpiration = 24 * time.Hour
-	SecretKey       = "secret-key"
+	// Токен доступа живёт недолго, клиент продлевает сессию refresh-токеном
+	TokenExpiration        = 15 * time.Minute
+	RefreshTokenExpiration = 30 * 24 * time.Hour
+	// Токен агента живёт недолго: агент сам получает новый по API-ключу
+	AgentTokenExpiration = time.Hour
 )
 
+// Типы субъектов токена: пользователи работают с /api/v1/*, агенты — с /internal/*
+const (
+	PrincipalUser  = "user"
+	PrincipalAgent = "agent"
+)
+
+// Роли: user и admin хранятся у пользователей, agent есть только у токенов агентов
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+	RoleAgent = "agent"
+)
+
+// IsUserRole сообщает, можно ли назначить роль пользователю
+func IsUserRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
+// Claims — содержимое токена. У пользовательских токенов RegisteredClaims.ID (jti) заполнен всегда:
+// по нему токен отзывается при выходе. SessionVersion — версия сессий пользователя на момент
+// выдачи: при отзыве всех сессий версия в БД растёт, и старые токены перестают приниматься.
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID         int    `json:"user_id,omitempty"`
+	AgentKeyID     int    `json:"agent_key_id,omitempty"`
+	Principal      string `json:"principal,omitempty"`
+	Role           string `json:"role,omitempty"`
+	SessionVersion int    `json:"sv,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID int) (string, error) {
+// UserRole возвращает роль из токена; у токенов, выданных до появления ролей, это RoleUser
+func (c *Claims) UserRole() string {
+	if c.Role == "" {
+		return RoleUser
+	}
+	return c.Role
+}
+
+// IsAgent сообщает, выдан ли токен агенту. Токены без principal выданы пользователям.
+func (c *Claims) IsAgent() bool {
+	return c.Principal == PrincipalAgent
+}
+
+// GenerateToken выдаёт токен доступа пользователю userID с ролью role
+// и текущей версией его сессий sessionVersion
+func GenerateToken(userID int, role string, sessionVersion int) (string, error) {
+	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
+		UserID:         userID,
+		Principal:      PrincipalUser,
+		Role:           role,
+		SessionVersion: sessionVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExpiration)),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(TokenExpiration)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(SecretKey))
+	return currentKeySet().sign(claims)
+}
+
+// GenerateAgentToken выдаёт токен агенту, предъявившему API-ключ keyID
+func GenerateAgentToken(keyID int) (string, error) {
+	claims := &Claims{
+		AgentKeyID: keyID,
+		Principal:  PrincipalAgent,
+		Role:       RoleAgent,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AgentTokenExpiration)),
+		},
+	}
+
+	return currentKeySet().sign(claims)
 }
 
 func ParseToken(tokenString string) (*Claims, error) {
+	return currentKeySet().ParseToken(tokenString)
+}
+
+// ParseToken проверяет токен ключами набора ks. Префикс "Bearer " допускается.
+func (ks *KeySet) ParseToken(tokenString string) (*Claims, error) {
 	if strings.HasPrefix(tokenString, "Bearer ") {
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(SecretKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, ks.verifyKey)
 
 	if err != nil {
 		return nil, err
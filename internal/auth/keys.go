@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Минимальная длина секрета HMAC-ключа
+const MinSecretLength = 16
+
+// Key — ключ, которым подписываются и проверяются токены
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey передаётся в SignedString, verifyKey возвращается при разборе токена
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet — действующие ключи: токен проверяется ключом из заголовка kid,
+// подписываются новые токены ключом signing.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+// Ключ без kid — секрет из jwt_secret; им подписаны токены, выданные до перехода на набор ключей
+const legacyKeyID = ""
+
+var keySet atomic.Pointer[KeySet]
+
+func init() {
+	// значение по умолчанию годится только для тестов
+	SetSecretKey("secret-key")
+}
+
+// SetSecretKey оставляет единственный ключ — секрет key без kid.
+func SetSecretKey(key string) {
+	ks, _ := NewKeySet(nil, key)
+	SetKeySet(ks)
+}
+
+// SetKeySet заменяет действующие ключи; безопасно вызывать во время обработки запросов.
+func SetKeySet(ks *KeySet) {
+	keySet.Store(ks)
+}
+
+func currentKeySet() *KeySet {
+	return keySet.Load()
+}
+
+// NewKeySet собирает ключи из файла ключей и секрета legacySecret (любое из них может быть пустым).
+// Подписывает новые токены самый новый ключ из файла, а если файла нет — секрет.
+func NewKeySet(file *KeyFile, legacySecret string) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key)}
+	if legacySecret != "" {
+		ks.signing = hmacKey(legacyKeyID, []byte(legacySecret))
+		ks.keys[legacyKeyID] = ks.signing
+	}
+	if file != nil {
+		for _, kc := range file.Keys {
+			key, err := kc.key(file.dir)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", kc.ID, err)
+			}
+			if _, ok := ks.keys[key.ID]; ok {
+				return nil, fmt.Errorf("duplicate key %q", kc.ID)
+			}
+			ks.keys[key.ID] = key
+			ks.signing = key
+		}
+	}
+	if ks.signing == nil {
+		return nil, errors.New("no signing keys configured")
+	}
+	return ks, nil
+}
+
+// SigningKeyID — kid ключа, которым подписываются новые токены
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.ID
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != legacyKeyID {
+		token.Header["kid"] = ks.signing.ID
+	}
+	return token.SignedString(ks.signing.signKey)
+}
+
+// verifyKey находит ключ по kid токена. Алгоритм токена должен совпадать с алгоритмом ключа,
+// иначе можно было бы, например, подписать токен публичным ключом как HMAC-секретом.
+func (ks *KeySet) verifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+func hmacKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// KeyFile — файл ключей подписи (YAML или JSON). Ключи перечислены от старых к новым.
+type KeyFile struct {
+	Keys []KeyConfig `yaml:"keys"`
+
+	// каталог файла: относительно него ищутся private_key_file
+	dir string
+}
+
+// KeyConfig — ключ в файле ключей. HS256 задаётся секретом, RS256 и EdDSA — закрытым
+// ключом в PEM-файле; их открытые ключи публикуются в JWKS.
+type KeyConfig struct {
+	ID             string    `yaml:"kid"`
+	Algorithm      string    `yaml:"alg,omitempty"`
+	Secret         string    `yaml:"secret,omitempty"`
+	PrivateKeyFile string    `yaml:"private_key_file,omitempty"`
+	CreatedAt      time.Time `yaml:"created_at"`
+}
+
+func (kc KeyConfig) key(dir string) (*Key, error) {
+	if kc.ID == "" {
+		return nil, errors.New("kid is required")
+	}
+
+	switch kc.Algorithm {
+	case "", AlgHS256:
+		if len(kc.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("secret must be at least %d characters", MinSecretLength)
+		}
+		return hmacKey(kc.ID, []byte(kc.Secret)), nil
+	case AlgRS256, AlgEdDSA:
+		if kc.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("private_key_file is required for %s", kc.Algorithm)
+		}
+		path := kc.PrivateKeyFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return loadPrivateKey(kc.ID, kc.Algorithm, path)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+}
+
+// ReadKeyFile читает файл ключей. Отсутствующий файл — пустой набор, чтобы
+// первый ключ можно было создать командой generate.
+func ReadKeyFile(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &KeyFile{dir: filepath.Dir(path)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	file := KeyFile{dir: filepath.Dir(path)}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// LoadKeySet читает файл ключей и собирает из него набор вместе с секретом legacySecret.
+func LoadKeySet(path, legacySecret string) (*KeySet, error) {
+	var file *KeyFile
+	if path != "" {
+		f, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+	return NewKeySet(file, legacySecret)
+}
+
+// Write атомарно записывает файл ключей: оркестратор при перечитывании не увидит его наполовину записанным.
+func (f *KeyFile) Write(path string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	// CreateTemp создаёт файл с правами 0600, секреты не должны читать другие пользователи
+	return os.Rename(tmp.Name(), path)
+}
+
+// Generate добавляет новый ключ алгоритма alg; он становится ключом подписи.
+// Закрытые ключи RS256 и EdDSA записываются в PEM-файл <kid>.pem рядом с файлом ключей.
+func (f *KeyFile) Generate(alg string) (KeyConfig, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return KeyConfig{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	kc := KeyConfig{
+		ID:        now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+		CreatedAt: now,
+	}
+
+	switch alg {
+	case "", AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return KeyConfig{}, err
+		}
+		kc.Secret = hex.EncodeToString(secret)
+	case AlgRS256, AlgEdDSA:
+		kc.Algorithm = alg
+		kc.PrivateKeyFile = kc.ID + ".pem"
+		if err := generatePrivateKey(alg, filepath.Join(f.dir, kc.PrivateKeyFile)); err != nil {
+			return KeyConfig{}, err
+		}
+	default:
+		return KeyConfig{}, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	f.Keys = append(f.Keys, kc)
+	return kc, nil
+}
+
+// Retire удаляет ключ: подписанные им токены перестают приниматься.
+// Последний ключ удалить нельзя, иначе нечем будет подписывать токены.
+// Возвращает удалённый ключ; его PEM-файл, если он был, не удаляется.
+func (f *KeyFile) Retire(kid string) (KeyConfig, error) {
+	for i, kc := range f.Keys {
+		if kc.ID != kid {
+			continue
+		}
+		if len(f.Keys) == 1 {
+			return KeyConfig{}, errors.New("cannot retire the only key, generate a new one first")
+		}
+		f.Keys = append(f.Keys[:i], f.Keys[i+1:]...)
+		return kc, nil
+	}
+	return KeyConfig{}, fmt.Errorf("key %q not found", kid)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMinPasswordLength = 8
+	// Всё, что длиннее 72 байт, bcrypt молча отбрасывает
+	MaxPasswordLength = 72
+)
+
+var (
+	ErrPasswordTooShort     = errors.New("password is too short")
+	ErrPasswordTooLong      = errors.New("password is too long")
+	ErrPasswordMatchesLogin = errors.New("password must not match the login")
+	ErrPasswordBreached     = errors.New("password appears in a list of breached passwords")
+)
+
+// PasswordPolicy — требования к паролям новых пользователей
+type PasswordPolicy struct {
+	MinLength int
+	// пароли из утечек; сравнение без учёта регистра
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy создаёт политику. breachedFile — необязательный файл с паролями из утечек,
+// по одному на строку; пустые строки и строки с # пропускаются.
+func NewPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	if minLength < 1 || minLength > MaxPasswordLength {
+		return nil, fmt.Errorf("minimum password length must be between 1 and %d, got %d", MaxPasswordLength, minLength)
+	}
+
+	p := &PasswordPolicy{MinLength: minLength}
+	if breachedFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	p.breached = make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return p, nil
+}
+
+// BreachedCount возвращает число паролей в списке утечек
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// Check проверяет пароль пользователя login. Ошибка оборачивает одну из ErrPassword*.
+// Минимальная длина считается в символах, максимальная — в байтах, как её ограничивает bcrypt.
+func (p *PasswordPolicy) Check(login, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrPasswordTooShort, p.MinLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("%w: at most %d bytes allowed", ErrPasswordTooLong, MaxPasswordLength)
+	}
+	if strings.EqualFold(password, login) {
+		return ErrPasswordMatchesLogin
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
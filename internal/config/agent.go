@@ -0,0 +1,188 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/logging"
+)
+
+type Agent struct {
+	ServerURL string `yaml:"server_url"`
+	// API-ключ агента, выданный администратором через /api/v1/admin/agent-keys
+	APIKey string `yaml:"api_key"`
+
+	// Пустой AgentID — агент получит случайный идентификатор при каждом запуске
+	AgentID string `yaml:"agent_id"`
+	// Операции, задачи с которыми агент готов вычислять
+	Operations []string `yaml:"operations"`
+
+	ComputingPower int    `yaml:"computing_power"`
+	Transport      string `yaml:"transport"`
+	GRPCAddr       string `yaml:"grpc_addr"`
+
+	// PollWait — сколько сервер держит запрос за задачей, PollInterval — пауза перед повтором после ошибки
+	PollWait        Duration `yaml:"poll_wait"`
+	PollInterval    Duration `yaml:"poll_interval"`
+	RequestTimeout  Duration `yaml:"request_timeout"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+
+	TLS      TLS    `yaml:"tls"`
+	LogLevel string `yaml:"log_level"`
+}
+
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func DefaultAgent() Agent {
+	return Agent{
+		ServerURL:       "http://localhost:8080",
+		Operations:      []string{"+", "-", "*", "/"},
+		ComputingPower:  1,
+		Transport:       "http",
+		GRPCAddr:        "localhost:9090",
+		PollWait:        Duration(30 * time.Second),
+		PollInterval:    Duration(5 * time.Second),
+		RequestTimeout:  Duration(10 * time.Second),
+		ShutdownTimeout: Duration(30 * time.Second),
+		LogLevel:        "info",
+	}
+}
+
+func (c *Agent) options() []option {
+	return []option{
+		{flag: "server-url", env: "AGENT_SERVER_URL", usage: "orchestrator HTTP address", set: stringOption(&c.ServerURL)},
+		{flag: "api-key", env: "AGENT_API_KEY", usage: "agent API key issued by an administrator", set: stringOption(&c.APIKey)},
+		{flag: "agent-id", env: "AGENT_ID", usage: "agent ID in the orchestrator registry, random if empty", set: stringOption(&c.AgentID)},
+		{flag: "operations", env: "AGENT_OPERATIONS", usage: "comma-separated operations the agent computes", set: listOption(&c.Operations)},
+		{flag: "computing-power", env: "COMPUTING_POWER", usage: "number of workers computing tasks concurrently", set: intOption(&c.ComputingPower)},
+		{flag: "transport", env: "AGENT_TRANSPORT", usage: "task transport: http or grpc", set: stringOption(&c.Transport)},
+		{flag: "grpc-addr", env: "GRPC_ADDR", usage: "orchestrator gRPC address", set: stringOption(&c.GRPCAddr)},
+		{flag: "poll-wait", env: "AGENT_POLL_WAIT", usage: "how long the server holds a task request when the queue is empty", set: durationOption(&c.PollWait)},
+		{flag: "poll-interval", env: "AGENT_POLL_INTERVAL", usage: "pause before retrying after an error", set: durationOption(&c.PollInterval)},
+		{flag: "request-timeout", env: "AGENT_REQUEST_TIMEOUT", usage: "timeout for requests to the orchestrator, on top of poll-wait", set: durationOption(&c.RequestTimeout)},
+		{flag: "shutdown-timeout", env: "AGENT_SHUTDOWN_TIMEOUT", usage: "how long to wait for in-flight tasks on shutdown", set: durationOption(&c.ShutdownTimeout)},
+		{flag: "tls-ca-file", env: "AGENT_TLS_CA_FILE", usage: "CA certificate to verify the orchestrator", set: stringOption(&c.TLS.CAFile)},
+		{flag: "tls-cert-file", env: "AGENT_TLS_CERT_FILE", usage: "client certificate for mutual TLS", set: stringOption(&c.TLS.CertFile)},
+		{flag: "tls-key-file", env: "AGENT_TLS_KEY_FILE", usage: "client key for mutual TLS", set: stringOption(&c.TLS.KeyFile)},
+		{flag: "tls-insecure-skip-verify", env: "AGENT_TLS_INSECURE_SKIP_VERIFY", usage: "do not verify the orchestrator certificate", isBool: true, set: boolOption(&c.TLS.InsecureSkipVerify)},
+		{flag: "log-level", env: "AGENT_LOG_LEVEL", usage: "debug, info, warn or error", set: stringOption(&c.LogLevel)},
+	}
+}
+
+// LoadAgent собирает конфигурацию агента из args (без имени программы) и переменных среды.
+// Второе значение — передан ли -print-config.
+func LoadAgent(args []string, lookupEnv func(string) (string, bool)) (*Agent, bool, error) {
+	cfg := DefaultAgent()
+	printConfig, err := load("agent", args, lookupEnv, "AGENT_CONFIG", &cfg, cfg.options())
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return &cfg, printConfig, nil
+}
+
+func (c *Agent) Validate() error {
+	var errs []error
+
+	if u, err := url.Parse(c.ServerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("server_url must be an http or https URL, got %q", c.ServerURL))
+	}
+	if c.APIKey == "" {
+		errs = append(errs, errors.New("api_key is required"))
+	}
+	if len(c.AgentID) > 64 || strings.Contains(c.AgentID, "/") {
+		errs = append(errs, fmt.Errorf("agent_id must be at most 64 characters without '/', got %q", c.AgentID))
+	}
+	if len(c.Operations) == 0 {
+		errs = append(errs, errors.New("operations must not be empty"))
+	}
+	for _, op := range c.Operations {
+		if !handlers.IsOperation(op) {
+			errs = append(errs, fmt.Errorf("unknown operation %q", op))
+		}
+	}
+	if c.ComputingPower < 1 {
+		errs = append(errs, fmt.Errorf("computing_power must be at least 1, got %d", c.ComputingPower))
+	}
+	switch c.Transport {
+	case "http":
+	case "grpc":
+		if c.GRPCAddr == "" {
+			errs = append(errs, errors.New("grpc_addr is required for grpc transport"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("transport must be http or grpc, got %q", c.Transport))
+	}
+	if c.PollWait <= 0 {
+		errs = append(errs, errors.New("poll_wait must be positive"))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, errors.New("poll_interval must be positive"))
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("request_timeout must be positive"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown_timeout must not be negative"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls cert_file and key_file must be set together"))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Masked возвращает копию конфигурации с замаскированными секретами для вывода
+func (c Agent) Masked() Agent {
+	c.APIKey = mask(c.APIKey)
+	return c
+}
+
+// ClientConfig собирает tls.Config для подключения к оркестратору.
+// Если TLS не настроен, возвращает nil: используются системные корневые сертификаты.
+func (t TLS) ClientConfig() (*tls.Config, error) {
+	if t == (TLS{}) {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
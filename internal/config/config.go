@@ -0,0 +1,200 @@
+// Package config собирает настройки бинарников из значений по умолчанию, файла
+// конфигурации (YAML или JSON), переменных среды и флагов — именно в таком порядке
+// приоритета, каждый следующий источник переопределяет предыдущий.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration записывается в файле конфигурации строкой вида "30s" или "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Маска, которой при выводе конфигурации заменяются секреты
+const secretMask = "********"
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return secretMask
+}
+
+// option — настройка, которую можно задать переменной среды env и флагом -flag.
+// Пустой flag — только переменная среды (например, устаревшее имя).
+type option struct {
+	flag   string
+	env    string
+	usage  string
+	isBool bool
+	set    func(value string) error
+}
+
+func stringOption(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func intOption(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+// listOption разбирает список через запятую
+func listOption(p *[]string) func(string) error {
+	return func(v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*p = items
+		return nil
+	}
+}
+
+func durationOption(p *Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*p = Duration(d)
+		return nil
+	}
+}
+
+func boolOption(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+// load заполняет target, в котором уже лежат значения по умолчанию: файлом из -config
+// (или переменной configEnv), затем переменными среды, затем флагами.
+// Возвращает true, если передан -print-config.
+func load(name string, args []string, lookupEnv func(string) (string, bool), configEnv string, target interface{}, options []option) (bool, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to YAML or JSON config file (env "+configEnv+")")
+	printConfig := fs.Bool("print-config", false, "print effective configuration with secrets masked and exit")
+
+	// флаги применяем последними, поэтому при разборе только запоминаем их
+	type flagValue struct {
+		opt   option
+		value string
+	}
+	var flagValues []flagValue
+	for _, opt := range options {
+		if opt.flag == "" {
+			continue
+		}
+		opt := opt
+		usage := opt.usage
+		if opt.env != "" {
+			usage += " (env " + opt.env + ")"
+		}
+		collect := func(v string) error {
+			flagValues = append(flagValues, flagValue{opt, v})
+			return nil
+		}
+		if opt.isBool {
+			fs.BoolFunc(opt.flag, usage, collect)
+		} else {
+			fs.Func(opt.flag, usage, collect)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv(configEnv)
+	}
+	if path != "" {
+		if err := loadFile(path, target); err != nil {
+			return false, err
+		}
+	}
+
+	for _, opt := range options {
+		if opt.env == "" {
+			continue
+		}
+		if v, ok := lookupEnv(opt.env); ok && v != "" {
+			if err := opt.set(v); err != nil {
+				return false, fmt.Errorf("%s: %w", opt.env, err)
+			}
+		}
+	}
+
+	for _, fv := range flagValues {
+		if err := fv.opt.set(fv.value); err != nil {
+			return false, fmt.Errorf("-%s: %w", fv.opt.flag, err)
+		}
+	}
+
+	return *printConfig, nil
+}
+
+func loadFile(path string, target interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// JSON — подмножество YAML, так что один разбор подходит для обоих форматов
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(target); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print выводит конфигурацию в формате YAML, пригодном для использования в качестве файла конфигурации.
+func Print(w io.Writer, cfg interface{}) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
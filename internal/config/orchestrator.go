@@ -0,0 +1,351 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+type Orchestrator struct {
+	ListenAddr      string   `yaml:"listen_addr"`
+	GRPCAddr        string   `yaml:"grpc_addr"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+
+	Database   Database   `yaml:"database"`
+	HTTP       HTTP       `yaml:"http"`
+	Migrations Migrations `yaml:"migrations"`
+	Features   Features   `yaml:"features"`
+
+	// Ключи подписи токенов: файл с набором ключей (с kid, для ротации) и/или секрет jwt_secret,
+	// которым подписаны токены, выданные до перехода на файл ключей
+	JWTSecret   string `yaml:"jwt_secret"`
+	JWTKeysFile string `yaml:"jwt_keys_file"`
+	AdminToken  string `yaml:"admin_token"`
+
+	Password       Password       `yaml:"password"`
+	Login          Login          `yaml:"login"`
+	Limits         Limits         `yaml:"limits"`
+	OperationTimes OperationTimes `yaml:"operation_times"`
+}
+
+type Database struct {
+	// DSN можно не хранить в конфигурации, а положить в файл DSNFile (например, секрет Docker или Kubernetes)
+	DSN             string   `yaml:"dsn"`
+	DSNFile         string   `yaml:"dsn_file"`
+	MaxOpenConns    int      `yaml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime"`
+}
+
+type HTTP struct {
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout"`
+}
+
+type Migrations struct {
+	Path string `yaml:"path"`
+	Auto bool   `yaml:"auto"`
+}
+
+type Features struct {
+	GRPC        bool `yaml:"grpc"`
+	AgentStream bool `yaml:"agent_stream"`
+	QueueNotify bool `yaml:"queue_notify"`
+}
+
+// Password — требования к паролям при регистрации
+type Password struct {
+	MinLength int `yaml:"min_length"`
+	// Необязательный файл с паролями из утечек, по одному на строку
+	BreachedListFile string `yaml:"breached_list_file"`
+}
+
+// Login — защита входа от перебора паролей, см. models.LoginProtection
+type Login struct {
+	FreeAttempts   int      `yaml:"free_attempts"`
+	IPFreeAttempts int      `yaml:"ip_free_attempts"`
+	BaseDelay      Duration `yaml:"base_delay"`
+	MaxDelay       Duration `yaml:"max_delay"`
+	ResetAfter     Duration `yaml:"reset_after"`
+}
+
+type Limits struct {
+	SubmissionsPerMinute  int `yaml:"submissions_per_minute"`
+	MaxPending            int `yaml:"max_pending"`
+	MaxTasksPerExpression int `yaml:"max_tasks_per_expression"`
+	MaxExpressionLength   int `yaml:"max_expression_length"`
+}
+
+type OperationTimes struct {
+	Addition       int `yaml:"addition_ms"`
+	Subtraction    int `yaml:"subtraction_ms"`
+	Multiplication int `yaml:"multiplication_ms"`
+	Division       int `yaml:"division_ms"`
+}
+
+func DefaultOrchestrator() Orchestrator {
+	return Orchestrator{
+		ListenAddr:      ":8080",
+		GRPCAddr:        ":9090",
+		ShutdownTimeout: Duration(10 * time.Second),
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+		},
+		HTTP: HTTP{
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(30 * time.Second),
+			// запрос агента за задачей может висеть до handlers.MaxTaskWait
+			WriteTimeout: Duration(handlers.MaxTaskWait + 30*time.Second),
+			IdleTimeout:  Duration(2 * time.Minute),
+		},
+		Migrations: Migrations{Path: "migrations", Auto: true},
+		Features:   Features{GRPC: true, AgentStream: true, QueueNotify: true},
+		Password:   Password{MinLength: auth.DefaultMinPasswordLength},
+		Login: Login{
+			FreeAttempts:   5,
+			IPFreeAttempts: 20,
+			BaseDelay:      Duration(time.Second),
+			MaxDelay:       Duration(15 * time.Minute),
+			ResetAfter:     Duration(time.Hour),
+		},
+		Limits: Limits{
+			SubmissionsPerMinute:  60,
+			MaxPending:            100,
+			MaxTasksPerExpression: 1000,
+			MaxExpressionLength:   10000,
+		},
+		OperationTimes: OperationTimes{
+			Addition:       1000,
+			Subtraction:    1000,
+			Multiplication: 2000,
+			Division:       2000,
+		},
+	}
+}
+
+func (c *Orchestrator) options() []option {
+	return []option{
+		{flag: "listen-addr", env: "LISTEN_ADDR", usage: "HTTP listen address", set: stringOption(&c.ListenAddr)},
+		{flag: "grpc-addr", env: "GRPC_ADDR", usage: "gRPC listen address", set: stringOption(&c.GRPCAddr)},
+		{flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "how long to drain requests and agent streams on shutdown", set: durationOption(&c.ShutdownTimeout)},
+		{flag: "db-dsn", env: "DB_CONN_STR", usage: "PostgreSQL connection string", set: stringOption(&c.Database.DSN)},
+		{flag: "db-dsn-file", env: "DB_CONN_STR_FILE", usage: "file containing the PostgreSQL connection string", set: stringOption(&c.Database.DSNFile)},
+		{flag: "db-max-open-conns", env: "DB_MAX_OPEN_CONNS", usage: "maximum number of open database connections", set: intOption(&c.Database.MaxOpenConns)},
+		{flag: "db-max-idle-conns", env: "DB_MAX_IDLE_CONNS", usage: "maximum number of idle database connections", set: intOption(&c.Database.MaxIdleConns)},
+		{flag: "db-conn-max-lifetime", env: "DB_CONN_MAX_LIFETIME", usage: "maximum lifetime of a database connection", set: durationOption(&c.Database.ConnMaxLifetime)},
+		{flag: "http-read-header-timeout", env: "HTTP_READ_HEADER_TIMEOUT", usage: "timeout for reading request headers", set: durationOption(&c.HTTP.ReadHeaderTimeout)},
+		{flag: "http-read-timeout", env: "HTTP_READ_TIMEOUT", usage: "timeout for reading the whole request", set: durationOption(&c.HTTP.ReadTimeout)},
+		{flag: "http-write-timeout", env: "HTTP_WRITE_TIMEOUT", usage: "timeout for writing the response, must exceed the task long-poll", set: durationOption(&c.HTTP.WriteTimeout)},
+		{flag: "http-idle-timeout", env: "HTTP_IDLE_TIMEOUT", usage: "keep-alive idle timeout", set: durationOption(&c.HTTP.IdleTimeout)},
+		{flag: "migrations-path", env: "MIGRATIONS_PATH", usage: "directory with database migrations", set: stringOption(&c.Migrations.Path)},
+		{flag: "migrations-auto", env: "MIGRATIONS_AUTO", usage: "apply migrations on startup", isBool: true, set: boolOption(&c.Migrations.Auto)},
+		{flag: "feature-grpc", env: "FEATURE_GRPC", usage: "serve the agent gRPC API", isBool: true, set: boolOption(&c.Features.GRPC)},
+		{flag: "feature-agent-stream", env: "FEATURE_AGENT_STREAM", usage: "serve the agent WebSocket stream", isBool: true, set: boolOption(&c.Features.AgentStream)},
+		{flag: "feature-queue-notify", env: "FEATURE_QUEUE_NOTIFY", usage: "wake agents on other replicas via LISTEN/NOTIFY", isBool: true, set: boolOption(&c.Features.QueueNotify)},
+		{flag: "jwt-secret", env: "JWT_SECRET", usage: "key for signing user tokens", set: stringOption(&c.JWTSecret)},
+		{flag: "jwt-keys-file", env: "JWT_KEYS_FILE", usage: "file with token signing keys, managed by the keys command", set: stringOption(&c.JWTKeysFile)},
+		{flag: "admin-token", env: "ADMIN_TOKEN", usage: "shared token for the admin API (X-Admin-Token), empty allows only users with the admin role", set: stringOption(&c.AdminToken)},
+		{flag: "password-min-length", env: "PASSWORD_MIN_LENGTH", usage: "minimum password length for new users", set: intOption(&c.Password.MinLength)},
+		{flag: "password-breached-list", env: "PASSWORD_BREACHED_LIST_FILE", usage: "file with breached passwords to reject, one per line", set: stringOption(&c.Password.BreachedListFile)},
+		{flag: "login-free-attempts", env: "LOGIN_FREE_ATTEMPTS", usage: "failed logins in a row per login before backoff starts", set: intOption(&c.Login.FreeAttempts)},
+		{flag: "login-ip-free-attempts", env: "LOGIN_IP_FREE_ATTEMPTS", usage: "failed logins in a row per IP before backoff starts", set: intOption(&c.Login.IPFreeAttempts)},
+		{flag: "login-base-delay", env: "LOGIN_BASE_DELAY", usage: "first backoff after the free attempts, doubled on every failure", set: durationOption(&c.Login.BaseDelay)},
+		{flag: "login-max-delay", env: "LOGIN_MAX_DELAY", usage: "longest lockout after failed logins", set: durationOption(&c.Login.MaxDelay)},
+		{flag: "login-reset-after", env: "LOGIN_RESET_AFTER", usage: "failed logins are forgotten after this long without new ones", set: durationOption(&c.Login.ResetAfter)},
+		{flag: "limit-submissions-per-minute", env: "LIMIT_SUBMISSIONS_PER_MINUTE", usage: "expressions a user may submit per minute, 0 for no limit", set: intOption(&c.Limits.SubmissionsPerMinute)},
+		{flag: "limit-max-pending", env: "LIMIT_MAX_PENDING", usage: "expressions a user may have in progress, 0 for no limit", set: intOption(&c.Limits.MaxPending)},
+		{flag: "limit-max-tasks-per-expression", env: "LIMIT_MAX_TASKS_PER_EXPRESSION", usage: "operations allowed in one expression, 0 for no limit", set: intOption(&c.Limits.MaxTasksPerExpression)},
+		{flag: "limit-max-expression-length", env: "LIMIT_MAX_EXPRESSION_LENGTH", usage: "characters allowed in one expression, 0 for no limit", set: intOption(&c.Limits.MaxExpressionLength)},
+		{flag: "time-addition-ms", env: "TIME_ADDITION_MS", usage: "duration of addition in milliseconds", set: intOption(&c.OperationTimes.Addition)},
+		{flag: "time-subtraction-ms", env: "TIME_SUBTRACTION_MS", usage: "duration of subtraction in milliseconds", set: intOption(&c.OperationTimes.Subtraction)},
+		// старые имена переменных; идут раньше новых, чтобы новые имели приоритет
+		{env: "TIME_MULTIPLICATION_MS", set: intOption(&c.OperationTimes.Multiplication)},
+		{env: "TIME_DIVISION_MS", set: intOption(&c.OperationTimes.Division)},
+		{flag: "time-multiplication-ms", env: "TIME_MULTIPLICATIONS_MS", usage: "duration of multiplication in milliseconds", set: intOption(&c.OperationTimes.Multiplication)},
+		{flag: "time-division-ms", env: "TIME_DIVISIONS_MS", usage: "duration of division in milliseconds", set: intOption(&c.OperationTimes.Division)},
+	}
+}
+
+// LoadOrchestrator собирает конфигурацию оркестратора из args (без имени программы) и переменных среды.
+// Второе значение — передан ли -print-config.
+func LoadOrchestrator(args []string, lookupEnv func(string) (string, bool)) (*Orchestrator, bool, error) {
+	cfg := DefaultOrchestrator()
+	printConfig, err := load("calculator", args, lookupEnv, "CALCULATOR_CONFIG", &cfg, cfg.options())
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cfg.Database.DSN == "" && cfg.Database.DSNFile != "" {
+		data, err := os.ReadFile(cfg.Database.DSNFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read database DSN file: %w", err)
+		}
+		cfg.Database.DSN = strings.TrimSpace(string(data))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return &cfg, printConfig, nil
+}
+
+func (c *Orchestrator) Validate() error {
+	var errs []error
+
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listen_addr is required"))
+	}
+	if c.Features.GRPC && c.GRPCAddr == "" {
+		errs = append(errs, errors.New("grpc_addr is required when the grpc feature is enabled"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database dsn is required: set DB_CONN_STR or database.dsn_file"))
+	}
+	if c.Database.MaxOpenConns < 1 {
+		errs = append(errs, errors.New("database max_open_conns must be at least 1"))
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database max_idle_conns must be between 0 and max_open_conns"))
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database conn_max_lifetime must not be negative"))
+	}
+
+	for _, t := range []struct {
+		name string
+		d    Duration
+	}{
+		{"read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"read_timeout", c.HTTP.ReadTimeout},
+		{"idle_timeout", c.HTTP.IdleTimeout},
+	} {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Errorf("http %s must be positive", t.name))
+		}
+	}
+	// иначе ответ на long-poll агента будет оборван
+	if time.Duration(c.HTTP.WriteTimeout) <= handlers.MaxTaskWait {
+		errs = append(errs, fmt.Errorf("http write_timeout must exceed the %s task long-poll", handlers.MaxTaskWait))
+	}
+
+	if c.Migrations.Auto && c.Migrations.Path == "" {
+		errs = append(errs, errors.New("migrations path is required when auto migrations are enabled"))
+	}
+
+	switch {
+	case c.JWTSecret == "" && c.JWTKeysFile == "":
+		errs = append(errs, errors.New("jwt_secret or jwt_keys_file is required"))
+	case c.JWTSecret != "" && len(c.JWTSecret) < auth.MinSecretLength:
+		errs = append(errs, fmt.Errorf("jwt_secret must be at least %d characters", auth.MinSecretLength))
+	case c.JWTKeysFile != "":
+		if _, err := auth.LoadKeySet(c.JWTKeysFile, c.JWTSecret); err != nil {
+			errs = append(errs, fmt.Errorf("jwt_keys_file: %w", err))
+		}
+	}
+
+	if _, err := c.Password.Policy(); err != nil {
+		errs = append(errs, fmt.Errorf("password: %w", err))
+	}
+
+	if c.Login.FreeAttempts < 1 || c.Login.IPFreeAttempts < 1 {
+		errs = append(errs, errors.New("login free_attempts and ip_free_attempts must be at least 1"))
+	}
+	if c.Login.BaseDelay <= 0 || c.Login.MaxDelay < c.Login.BaseDelay {
+		errs = append(errs, errors.New("login base_delay must be positive and not exceed max_delay"))
+	}
+	// иначе счётчик сбросится раньше, чем истечёт блокировка
+	if c.Login.ResetAfter < c.Login.MaxDelay {
+		errs = append(errs, errors.New("login reset_after must not be shorter than max_delay"))
+	}
+
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"limits submissions_per_minute", c.Limits.SubmissionsPerMinute},
+		{"limits max_pending", c.Limits.MaxPending},
+		{"limits max_tasks_per_expression", c.Limits.MaxTasksPerExpression},
+		{"limits max_expression_length", c.Limits.MaxExpressionLength},
+		{"operation_times addition_ms", c.OperationTimes.Addition},
+		{"operation_times subtraction_ms", c.OperationTimes.Subtraction},
+		{"operation_times multiplication_ms", c.OperationTimes.Multiplication},
+		{"operation_times division_ms", c.OperationTimes.Division},
+	} {
+		if v.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", v.name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Masked возвращает копию конфигурации с замаскированными секретами для вывода
+func (c Orchestrator) Masked() Orchestrator {
+	c.Database.DSN = maskDSN(c.Database.DSN)
+	c.JWTSecret = mask(c.JWTSecret)
+	c.AdminToken = mask(c.AdminToken)
+	return c
+}
+
+var dsnPasswordRe = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// maskDSN скрывает пароль в строке подключения, оставляя остальное для диагностики
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), secretMask)
+		}
+		return u.String()
+	}
+	return dsnPasswordRe.ReplaceAllString(dsn, "${1}"+secretMask)
+}
+
+// Policy загружает политику паролей, включая список утечек
+func (p Password) Policy() (*auth.PasswordPolicy, error) {
+	return auth.NewPasswordPolicy(p.MinLength, p.BreachedListFile)
+}
+
+func (l Login) Protection() models.LoginProtection {
+	return models.LoginProtection{
+		FreeAttempts:   l.FreeAttempts,
+		IPFreeAttempts: l.IPFreeAttempts,
+		BaseDelay:      time.Duration(l.BaseDelay),
+		MaxDelay:       time.Duration(l.MaxDelay),
+		ResetAfter:     time.Duration(l.ResetAfter),
+	}
+}
+
+func (l Limits) SubmissionLimits() models.SubmissionLimits {
+	return models.SubmissionLimits{
+		SubmissionsPerMinute:  l.SubmissionsPerMinute,
+		MaxPending:            l.MaxPending,
+		MaxTasksPerExpression: l.MaxTasksPerExpression,
+		MaxExpressionLength:   l.MaxExpressionLength,
+	}
+}
+
+// ByOperator возвращает время операций в виде, который ожидает handlers.SetDefaultOperationTimes
+func (t OperationTimes) ByOperator() map[string]int {
+	return map[string]int{
+		"+": t.Addition,
+		"-": t.Subtraction,
+		"*": t.Multiplication,
+		"/": t.Division,
+	}
+}
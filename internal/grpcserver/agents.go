@@ -0,0 +1,41 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ключ метаданных с идентификатором агента, аналог заголовка handlers.AgentIDHeader
+const agentIDMetadata = "x-agent-id"
+
+func agentID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(agentIDMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// requestAgent — обёртка над handlers.RequestAgent с ошибками в виде статусов gRPC
+func requestAgent(ctx context.Context, s *storage.PostgresStorage) (*models.Agent, error) {
+	agent, err := handlers.RequestAgent(ctx, s, agentID(ctx))
+	if err != nil {
+		if errors.Is(err, handlers.ErrAgentIDRequired) {
+			return nil, status.Error(codes.InvalidArgument, "x-agent-id metadata required")
+		}
+		if errors.Is(err, handlers.ErrAgentNotRegistered) {
+			return nil, status.Error(codes.FailedPrecondition, "agent is not registered")
+		}
+		log.Printf("Failed to get agent: %v", err)
+		return nil, status.Error(codes.Internal, "failed to get agent")
+	}
+	return agent, nil
+}
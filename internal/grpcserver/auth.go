@@ -0,0 +1,70 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authenticate проверяет токен агента из метаданных authorization так же, как middleware.AgentAuthMiddleware
+func authenticate(ctx context.Context, s *storage.PostgresStorage) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata required")
+	}
+
+	claims, err := auth.ParseToken(values[0])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !claims.IsAgent() {
+		return nil, status.Error(codes.PermissionDenied, "agent credentials required")
+	}
+
+	active, err := s.AgentKeyActive(ctx, claims.AgentKeyID)
+	if err != nil {
+		log.Printf("Failed to check agent key %d: %v", claims.AgentKeyID, err)
+		return nil, status.Error(codes.Internal, "failed to check credentials")
+	}
+	if !active {
+		return nil, status.Error(codes.Unauthenticated, "agent key revoked")
+	}
+
+	return context.WithValue(ctx, "agent_key_id", claims.AgentKeyID), nil
+}
+
+func authUnaryInterceptor(s *storage.PostgresStorage) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+func authStreamInterceptor(s *storage.PostgresStorage) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), s)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+	}
+}
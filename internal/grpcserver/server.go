@@ -0,0 +1,186 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agentpb"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	agentpb.UnimplementedAgentServiceServer
+	store *storage.PostgresStorage
+}
+
+func NewServer(store *storage.PostgresStorage) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(authUnaryInterceptor(store)),
+		grpc.StreamInterceptor(authStreamInterceptor(store)),
+		// keepalive заменяет heartbeat: оборванный стрим обнаружится без сообщений от агента
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    30 * time.Second,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	agentpb.RegisterAgentServiceServer(srv, &Server{store: store})
+	return srv
+}
+
+func (s *Server) GetTask(ctx context.Context, req *agentpb.GetTaskRequest) (*agentpb.GetTaskResponse, error) {
+	agent, err := requestAgent(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TaskId != "" {
+		task, err := s.store.GetTaskByID(ctx, req.TaskId)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "task not found")
+		}
+		return &agentpb.GetTaskResponse{Task: toProtoTask(task)}, nil
+	}
+
+	wait := min(time.Duration(max(req.WaitMs, 0))*time.Millisecond, handlers.MaxTaskWait)
+	task, err := s.store.WaitNextTaskFromQueue(ctx, wait, agent.ID, handlers.AgentOperations(agent))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		if errors.Is(err, storage.ErrShuttingDown) {
+			return nil, status.Error(codes.Unavailable, "server is shutting down, retry elsewhere")
+		}
+		log.Printf("Database error: %v", err)
+		return nil, status.Error(codes.Internal, "failed to get task")
+	}
+	if task == nil {
+		return nil, status.Error(codes.NotFound, "no tasks available")
+	}
+
+	return &agentpb.GetTaskResponse{Task: toProtoTask(task)}, nil
+}
+
+func (s *Server) SubmitResult(ctx context.Context, req *agentpb.SubmitResultRequest) (*agentpb.SubmitResultResponse, error) {
+	agent, err := requestAgent(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+	task, err := s.store.GetTaskByID(ctx, req.TaskId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+
+	switch req.Status {
+	case "completed":
+		if req.Result == nil {
+			return nil, status.Error(codes.InvalidArgument, "result is required for completed status")
+		}
+		err = handlers.CompleteTask(ctx, s.store, task, agent.ID, req.GetResult())
+		if err == nil {
+			handlers.RecordAgentTaskCompleted(s.store, agent.ID, handlers.AgentKeyID(ctx))
+		}
+	case "pending":
+		err = s.store.RequeueTask(ctx, task.ID, agent.ID)
+	case "failed":
+		err = s.store.FailTask(ctx, task.ID, agent.ID)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", req.Status)
+	}
+	if errors.Is(err, storage.ErrTaskNotAssigned) {
+		// задачу уже посчитали, вернули в очередь или выдали другому агенту
+		return nil, status.Error(codes.Aborted, "task is not assigned to this agent")
+	}
+	if err != nil {
+		log.Printf("Failed to process %s result of task %s: %v", req.Status, task.ID, err)
+		return nil, status.Error(codes.Internal, "failed to update task")
+	}
+
+	return &agentpb.SubmitResultResponse{}, nil
+}
+
+func (s *Server) Heartbeat(ctx context.Context, req *agentpb.HeartbeatRequest) (*agentpb.HeartbeatResponse, error) {
+	if _, err := requestAgent(ctx, s.store); err != nil {
+		return nil, err
+	}
+	return &agentpb.HeartbeatResponse{ServerTimeUnixMs: time.Now().UnixMilli()}, nil
+}
+
+func (s *Server) StreamTasks(stream agentpb.AgentService_StreamTasksServer) error {
+	msg, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	hello := msg.GetHello()
+	if hello == nil {
+		return status.Error(codes.InvalidArgument, "first message must be hello")
+	}
+
+	agent, err := requestAgent(stream.Context(), s.store)
+	if err != nil {
+		return err
+	}
+
+	ts := handlers.NewTaskStream(s.store, int(hello.Concurrency), agent, handlers.AgentKeyID(stream.Context()))
+	log.Printf("gRPC agent stream opened with concurrency %d", ts.Concurrency())
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer cancel()
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if result := msg.GetResult(); result != nil {
+				ts.HandleResult(result.TaskId, result.Status, result.Result)
+			} else if msg.GetHeartbeat() != nil {
+				ts.Heartbeat(ctx)
+			}
+		}
+	}()
+
+	// Recv разблокируется только после выхода из обработчика,
+	// поэтому невыполненные задачи возвращаем в очередь уже после него
+	go func() {
+		<-readerDone
+		ts.Close()
+		log.Println("gRPC agent stream closed")
+	}()
+
+	err = ts.Dispatch(ctx, func(task *models.Task) error {
+		return stream.Send(&agentpb.TaskAssignment{Task: toProtoTask(task)})
+	})
+	if errors.Is(err, storage.ErrShuttingDown) {
+		return status.Error(codes.Unavailable, "server is shutting down, retry elsewhere")
+	}
+
+	return ctx.Err()
+}
+
+func toProtoTask(task *models.Task) *agentpb.Task {
+	return &agentpb.Task{
+		Id:            task.ID,
+		ExpressionId:  int32(task.ExpressionID),
+		Arg1:          task.Arg1,
+		Arg2:          task.Arg2,
+		Operation:     task.Operation,
+		OperationTime: int32(task.OperationTime),
+		Status:        task.Status,
+		Result:        task.Result,
+		DependsOn:     task.DependsOn,
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MeHandler: GET /api/v1/me — профиль пользователя и статистика, DELETE — удаление учётной записи
+// вместе с личными выражениями и задачами. Удаление подтверждается паролем.
+func MeHandler(s *storage.PostgresStorage, protection models.LoginProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+
+		switch r.Method {
+		case http.MethodGet:
+			user, err := s.GetUserByID(r.Context(), userID)
+			if err != nil {
+				log.Printf("Failed to get user %d: %v", userID, err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			usage, err := s.GetUserUsage(r.Context(), userID)
+			if err != nil {
+				log.Printf("Failed to get usage of user %d: %v", userID, err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			respondWithJSON(w, http.StatusOK, models.Profile{User: *user, Usage: *usage})
+		case http.MethodDelete:
+			if !requireTokenSession(w, r) {
+				return
+			}
+			var req models.DeleteAccountRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request")
+				return
+			}
+			if _, ok := verifyPassword(w, r, s, userID, req.Password, protection); !ok {
+				return
+			}
+
+			if err := s.DeleteUser(r.Context(), userID); err != nil {
+				if errors.Is(err, storage.ErrLastOwner) {
+					WriteError(w, http.StatusConflict, CodeLastOwner,
+						"Appoint another owner or delete your organizations before deleting the account")
+					return
+				}
+				log.Printf("Failed to delete user %d: %v", userID, err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			log.Printf("User %d deleted their account", userID)
+			respondWithJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// ChangePasswordHandler: PUT /api/v1/me/password меняет пароль по старому паролю.
+// Все сессии пользователя отзываются; в ответе — новая сессия для текущего клиента.
+func ChangePasswordHandler(s *storage.PostgresStorage, policy *auth.PasswordPolicy, protection models.LoginProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if !requireTokenSession(w, r) {
+			return
+		}
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		user, ok := verifyPassword(w, r, s, userID, req.OldPassword, protection)
+		if !ok {
+			return
+		}
+		if err := policy.Check(user.Login, req.NewPassword); err != nil {
+			code := CodeWeakPassword
+			if errors.Is(err, auth.ErrPasswordBreached) {
+				code = CodeBreachedPassword
+			}
+			WriteError(w, http.StatusBadRequest, code, "Password rejected: "+err.Error())
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := s.SetPassword(r.Context(), userID, string(hashedPassword)); err != nil {
+			log.Printf("Failed to change password of user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// новая сессия выдаётся с новой версией сессий пользователя
+		user, err = s.GetUserByID(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		resp, err := issueSession(r.Context(), s, user)
+		if err != nil {
+			log.Printf("Failed to issue tokens for user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// verifyPassword проверяет пароль пользователя перед опасным действием. Неверный пароль
+// засчитывается как неудачный вход, чтобы украденный токен не позволял подбирать пароль.
+// При ошибке ответ уже отправлен.
+func verifyPassword(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, userID int,
+	password string, protection models.LoginProtection) (*models.User, bool) {
+	user, err := s.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return nil, false
+	}
+	ip := clientIP(r)
+
+	retryAfter, err := s.BeginLoginAttempt(r.Context(), user.Login, ip, protection)
+	if err != nil {
+		log.Printf("Failed to check login attempts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+	if retryAfter > 0 {
+		recordFailedLogin(s, user.Login, ip, "locked")
+		setRetryAfter(w, retryAfter)
+		WriteError(w, http.StatusTooManyRequests, CodeLoginLocked, "Too many failed password attempts, try again later")
+		return nil, false
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		recordFailedLogin(s, user.Login, ip, "wrong_password")
+		WriteError(w, http.StatusForbidden, CodeInvalidCredentials, "Invalid password")
+		return nil, false
+	}
+
+	if err := s.FinishLoginAttempt(r.Context(), user.Login, ip, protection); err != nil {
+		log.Printf("Failed to reset login attempts of user %d: %v", userID, err)
+	}
+	return user, true
+}
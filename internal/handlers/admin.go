@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+func GetSchedulingHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := s.ListUserScheduling(r.Context())
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get scheduling state")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, users)
+	}
+}
+
+func SetUserWeightHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		userID, err := strconv.Atoi(r.URL.Path[len("/api/v1/admin/scheduling/"):])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		var req struct {
+			Weight int `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if req.Weight < 1 {
+			respondWithError(w, http.StatusBadRequest, "Weight must be a positive integer")
+			return
+		}
+
+		if err := s.SetUserWeight(r.Context(), userID, req.Weight); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "User not found")
+				return
+			}
+			log.Printf("Failed to set weight for user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to set weight")
+			return
+		}
+
+		log.Printf("Scheduling weight for user %d set to %d", userID, req.Weight)
+		respondWithJSON(w, http.StatusOK, map[string]int{"user_id": userID, "weight": req.Weight})
+	}
+}
+
+// AdminUsersHandler: GET /api/v1/admin/users — все пользователи с ролями
+func AdminUsersHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		users, err := s.ListUsers(r.Context())
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get users")
+			return
+		}
+		if users == nil {
+			users = []models.User{}
+		}
+		respondWithJSON(w, http.StatusOK, users)
+	}
+}
+
+// AdminUserHandler обслуживает /api/v1/admin/users/{id}/...:
+// PUT .../role меняет роль, DELETE .../sessions отзывает все токены пользователя.
+func AdminUserHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr, action, _ := strings.Cut(r.URL.Path[len("/api/v1/admin/users/"):], "/")
+		userID, err := strconv.Atoi(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		switch {
+		case action == "role" && r.Method == http.MethodPut:
+			setUserRole(w, r, s, userID)
+		case action == "sessions" && r.Method == http.MethodDelete:
+			revokeUserSessions(w, r, s, userID)
+		case action == "role" || action == "sessions":
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		default:
+			respondWithError(w, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+// setUserRole меняет роль и отзывает сессии пользователя, чтобы старая роль
+// не действовала до истечения уже выданных токенов.
+func setUserRole(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, userID int) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if !auth.IsUserRole(req.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be user or admin")
+		return
+	}
+
+	if err := s.SetUserRole(r.Context(), userID, req.Role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Failed to set role of user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to set role")
+		return
+	}
+
+	log.Printf("Role of user %d set to %s", userID, req.Role)
+	respondWithJSON(w, http.StatusOK, map[string]any{"user_id": userID, "role": req.Role})
+}
+
+// revokeUserSessions отзывает все токены и API-ключи пользователя, например если его токен утёк.
+// Войти заново пользователь может сразу.
+func revokeUserSessions(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, userID int) {
+	if err := s.RevokeUserSessions(r.Context(), userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	log.Printf("All sessions of user %d revoked", userID)
+	respondWithJSON(w, http.StatusOK, map[string]int{"user_id": userID})
+}
+
+// AdminExpressionHandler: GET /api/v1/admin/expressions/{id} — любое выражение вместе с задачами
+func AdminExpressionHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		id, err := strconv.Atoi(r.URL.Path[len("/api/v1/admin/expressions/"):])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid expression ID")
+			return
+		}
+
+		expr, err := s.GetExpressionByID(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Expression not found")
+				return
+			}
+			log.Printf("Failed to get expression %d: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get expression")
+			return
+		}
+
+		tasks, err := s.GetTasksByExpressionID(r.Context(), id)
+		if err != nil {
+			log.Printf("Failed to get tasks of expression %d: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get expression")
+			return
+		}
+		if tasks == nil {
+			tasks = []*models.Task{}
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			*models.Expression
+			Tasks []*models.Task `json:"tasks"`
+		}{expr, tasks})
+	}
+}
+
+// PurgeQueueHandler: DELETE /api/v1/admin/queue очищает очередь задач и отменяет
+// невычисленные выражения всех пользователей.
+func PurgeQueueHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		cancelled, err := s.PurgeTaskQueue(r.Context())
+		if err != nil {
+			log.Printf("Failed to purge task queue: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to purge queue")
+			return
+		}
+
+		log.Printf("Task queue purged, %d expressions cancelled", cancelled)
+		respondWithJSON(w, http.StatusOK, map[string]int64{"cancelled_expressions": cancelled})
+	}
+}
+
+// AdminAgentsHandler: GET /api/v1/admin/agents — реестр агентов для администратора
+func AdminAgentsHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		agents, err := s.ListAgents(r.Context())
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get agents")
+			return
+		}
+		if agents == nil {
+			agents = []models.Agent{}
+		}
+		respondWithJSON(w, http.StatusOK, agents)
+	}
+}
+
+// LoginLockoutsHandler: GET /api/v1/admin/login-lockouts — заблокированные логины и IP,
+// DELETE /api/v1/admin/login-lockouts?login=<логин> (или ?ip=<адрес>) — снять блокировку.
+func LoginLockoutsHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			lockouts, err := s.ListLoginLockouts(r.Context())
+			if err != nil {
+				log.Printf("DB error: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to get login lockouts")
+				return
+			}
+			if lockouts == nil {
+				lockouts = []models.LoginLockout{}
+			}
+			respondWithJSON(w, http.StatusOK, lockouts)
+		case http.MethodDelete:
+			unlockLogin(w, r, s)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func unlockLogin(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage) {
+	query := r.URL.Query()
+	login, ip := query.Get("login"), query.Get("ip")
+
+	scope, key := storage.LoginScope, login
+	switch {
+	case login != "" && ip == "":
+	case ip != "" && login == "":
+		scope, key = storage.IPScope, ip
+	default:
+		respondWithError(w, http.StatusBadRequest, "Exactly one of login or ip is required")
+		return
+	}
+
+	if err := s.UnlockLogin(r.Context(), scope, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "No failed login attempts recorded")
+			return
+		}
+		log.Printf("Failed to unlock %s %q: %v", scope, key, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to unlock")
+		return
+	}
+
+	log.Printf("Login attempts of %s %q unlocked", scope, key)
+	respondWithJSON(w, http.StatusOK, map[string]string{"scope": scope, "key": key})
+}
+
+const (
+	defaultFailedLoginsLimit = 100
+	maxFailedLoginsLimit     = 1000
+)
+
+// FailedLoginsHandler: GET /api/v1/admin/failed-logins?limit=N — последние неудачные входы
+func FailedLoginsHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		limit := defaultFailedLoginsLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxFailedLoginsLimit {
+				respondWithError(w, http.StatusBadRequest, "Limit must be between 1 and 1000")
+				return
+			}
+			limit = n
+		}
+
+		logins, err := s.ListFailedLogins(r.Context(), limit)
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get failed logins")
+			return
+		}
+		if logins == nil {
+			logins = []models.FailedLogin{}
+		}
+		respondWithJSON(w, http.StatusOK, logins)
+	}
+}
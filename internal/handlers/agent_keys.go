@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// AgentTokenHandler: POST /internal/agent/token с заголовком "Authorization: ApiKey <ключ>"
+// обменивает API-ключ агента на короткоживущий токен для /internal/*.
+func AgentTokenHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		key, ok := auth.APIKeyFromHeader(r.Header.Get("Authorization"))
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		agentKey, err := s.UseAgentKey(r.Context(), auth.HashAPIKey(key))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+				return
+			}
+			log.Printf("Failed to check agent key: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		token, err := auth.GenerateAgentToken(agentKey.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.LoginResponse{
+			Token:     token,
+			ExpiresIn: int(auth.AgentTokenExpiration.Seconds()),
+		})
+	}
+}
+
+// AgentKeysHandler: GET /api/v1/admin/agent-keys — список ключей, POST — выдача нового.
+func AgentKeysHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := s.ListAgentKeys(r.Context())
+			if err != nil {
+				log.Printf("DB error: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to get agent keys")
+				return
+			}
+			if keys == nil {
+				keys = []models.AgentKey{}
+			}
+			respondWithJSON(w, http.StatusOK, keys)
+		case http.MethodPost:
+			createAgentKey(w, r, s)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func createAgentKey(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1-255 characters")
+		return
+	}
+
+	key, err := auth.NewAPIKey(auth.AgentKeyPrefix)
+	if err != nil {
+		log.Printf("Failed to generate agent key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create agent key")
+		return
+	}
+
+	agentKey := models.AgentKey{
+		Name:    req.Name,
+		Prefix:  auth.APIKeyDisplayPrefix(key),
+		KeyHash: auth.HashAPIKey(key),
+	}
+	if err := s.CreateAgentKey(r.Context(), &agentKey); err != nil {
+		log.Printf("Failed to create agent key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create agent key")
+		return
+	}
+
+	log.Printf("Agent key %d (%s) issued", agentKey.ID, agentKey.Name)
+	// ключ целиком показывается только в этом ответе
+	respondWithJSON(w, http.StatusCreated, struct {
+		models.AgentKey
+		Key string `json:"key"`
+	}{agentKey, key})
+}
+
+// RevokeAgentKeyHandler: DELETE /api/v1/admin/agent-keys/{id}. Агенты с этим ключом
+// теряют доступ к /internal/* сразу, не дожидаясь истечения выданных им токенов.
+func RevokeAgentKeyHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		id, err := strconv.Atoi(r.URL.Path[len("/api/v1/admin/agent-keys/"):])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid key ID")
+			return
+		}
+
+		agentKey, err := s.RevokeAgentKey(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Agent key not found")
+				return
+			}
+			log.Printf("Failed to revoke agent key %d: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke agent key")
+			return
+		}
+
+		log.Printf("Agent key %d (%s) revoked", agentKey.ID, agentKey.Name)
+		respondWithJSON(w, http.StatusOK, agentKey)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// Заголовок, которым зарегистрированный агент представляется в запросах к /internal/*
+const AgentIDHeader = "X-Agent-ID"
+
+const maxAgentIDLength = 64
+
+var (
+	ErrAgentNotRegistered = errors.New("agent is not registered")
+	ErrAgentIDRequired    = errors.New("agent ID is required")
+)
+
+// AgentKeyID возвращает ключ, которым аутентифицирован агент (кладут AgentAuthMiddleware и gRPC-интерцепторы)
+func AgentKeyID(ctx context.Context) int {
+	id, _ := ctx.Value("agent_key_id").(int)
+	return id
+}
+
+// AgentsHandler: GET /internal/agents — список агентов, POST — регистрация агента.
+func AgentsHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			agents, err := s.ListAgents(r.Context())
+			if err != nil {
+				log.Printf("DB error: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to get agents")
+				return
+			}
+			if agents == nil {
+				agents = []models.Agent{}
+			}
+			respondWithJSON(w, http.StatusOK, agents)
+		case http.MethodPost:
+			registerAgent(w, r, s)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func registerAgent(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage) {
+	var agent models.Agent
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	if agent.ID == "" || len(agent.ID) > maxAgentIDLength || strings.Contains(agent.ID, "/") {
+		respondWithError(w, http.StatusBadRequest, "Agent ID must be 1-64 characters without '/'")
+		return
+	}
+	if len(agent.Operations) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Agent must support at least one operation")
+		return
+	}
+	for _, op := range agent.Operations {
+		if !IsOperation(op) {
+			respondWithError(w, http.StatusBadRequest, "Unknown operation: "+op)
+			return
+		}
+	}
+	if agent.Concurrency < 1 {
+		agent.Concurrency = 1
+	}
+
+	if err := s.RegisterAgent(r.Context(), &agent, AgentKeyID(r.Context())); err != nil {
+		if errors.Is(err, storage.ErrAgentIDTaken) {
+			respondWithError(w, http.StatusConflict, "Agent ID is registered with another key")
+			return
+		}
+		log.Printf("Failed to register agent: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to register agent")
+		return
+	}
+
+	log.Printf("Agent %s registered from %s (version %s, operations %v, concurrency %d)",
+		agent.ID, agent.Hostname, agent.Version, agent.Operations, agent.Concurrency)
+	respondWithJSON(w, http.StatusOK, agent)
+}
+
+// AgentHeartbeatHandler: POST /internal/agents/{id}/heartbeat
+func AgentHeartbeatHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/internal/agents/"), "/heartbeat")
+		if !ok || id == "" {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+
+		agent, err := s.TouchAgent(r.Context(), id, AgentKeyID(r.Context()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Agent not registered")
+				return
+			}
+			log.Printf("Failed to record heartbeat of agent %s: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to record heartbeat")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, agent)
+	}
+}
+
+// RequestAgent возвращает агента, от имени которого пришёл запрос, и отмечает его активность.
+// Агент должен представиться и быть зарегистрирован с тем же ключом, которым аутентифицирован
+// запрос, иначе ему выдавались бы задачи без учёта его операций или от имени чужого агента.
+func RequestAgent(ctx context.Context, s *storage.PostgresStorage, agentID string) (*models.Agent, error) {
+	if agentID == "" {
+		return nil, ErrAgentIDRequired
+	}
+	agent, err := s.TouchAgent(ctx, agentID, AgentKeyID(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAgentNotRegistered
+	}
+	return agent, err
+}
+
+// requestAgent — RequestAgent для HTTP-обработчиков: если агента нет, отвечает клиенту и возвращает false
+func requestAgent(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage) (*models.Agent, bool) {
+	agent, err := RequestAgent(r.Context(), s, r.Header.Get(AgentIDHeader))
+	switch {
+	case err == nil:
+		return agent, true
+	case errors.Is(err, ErrAgentIDRequired):
+		respondWithError(w, http.StatusBadRequest, "X-Agent-ID header required")
+	case errors.Is(err, ErrAgentNotRegistered):
+		// агент зарегистрируется заново и повторит запрос
+		respondWithError(w, http.StatusConflict, "Agent is not registered")
+	default:
+		log.Printf("Failed to get agent: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get agent")
+	}
+	return nil, false
+}
+
+// AgentOperations возвращает операции, задачи с которыми можно выдавать агенту; nil — любые
+func AgentOperations(agent *models.Agent) []string {
+	if agent == nil {
+		return nil
+	}
+	return agent.Operations
+}
+
+// RecordAgentTaskCompleted засчитывает выполненную задачу агенту, зарегистрированному с ключом keyID
+func RecordAgentTaskCompleted(s *storage.PostgresStorage, agentID string, keyID int) {
+	if agentID == "" {
+		return
+	}
+	if err := s.IncrementAgentTasksCompleted(context.Background(), agentID, keyID); err != nil {
+		log.Printf("Failed to update completed tasks of agent %s: %v", agentID, err)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// APIKeysHandler: GET /api/v1/keys — личные API-ключи пользователя, POST — выдача нового.
+func APIKeysHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireTokenSession(w, r) {
+			return
+		}
+		userID := r.Context().Value("user_id").(int)
+
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := s.ListAPIKeys(r.Context(), userID)
+			if err != nil {
+				log.Printf("DB error: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to get API keys")
+				return
+			}
+			if keys == nil {
+				keys = []models.APIKey{}
+			}
+			respondWithJSON(w, http.StatusOK, keys)
+		case http.MethodPost:
+			createAPIKey(w, r, s, userID)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, userID int) {
+	var req struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1-255 characters")
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = auth.ScopeRead
+	}
+	if !auth.IsScope(req.Scope) {
+		respondWithError(w, http.StatusBadRequest, "Scope must be read or submit")
+		return
+	}
+
+	key, err := auth.NewAPIKey(auth.UserKeyPrefix)
+	if err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:  userID,
+		Name:    req.Name,
+		Prefix:  auth.APIKeyDisplayPrefix(key),
+		KeyHash: auth.HashAPIKey(key),
+		Scope:   req.Scope,
+	}
+	if err := s.CreateAPIKey(r.Context(), &apiKey); err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	log.Printf("API key %d (%s, %s) issued to user %d", apiKey.ID, apiKey.Name, apiKey.Scope, userID)
+	// ключ целиком показывается только в этом ответе
+	respondWithJSON(w, http.StatusCreated, struct {
+		models.APIKey
+		Key string `json:"key"`
+	}{apiKey, key})
+}
+
+// RevokeAPIKeyHandler: DELETE /api/v1/keys/{id} отзывает личный ключ пользователя
+func RevokeAPIKeyHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireTokenSession(w, r) {
+			return
+		}
+		if r.Method != http.MethodDelete {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		userID := r.Context().Value("user_id").(int)
+
+		id, err := strconv.Atoi(r.URL.Path[len("/api/v1/keys/"):])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid key ID")
+			return
+		}
+
+		apiKey, err := s.RevokeAPIKey(r.Context(), userID, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "API key not found")
+				return
+			}
+			log.Printf("Failed to revoke API key %d: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+			return
+		}
+
+		log.Printf("API key %d (%s) of user %d revoked", apiKey.ID, apiKey.Name, userID)
+		respondWithJSON(w, http.StatusOK, apiKey)
+	}
+}
+
+// requireTokenSession не даёт управлять ключами и учётной записью по самому API-ключу:
+// утёкший ключ не должен позволять выпустить себе новые или сменить пароль.
+func requireTokenSession(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.Context().Value("claims").(*auth.Claims); !ok {
+		respondWithError(w, http.StatusForbidden, "This action requires a login token, not an API key")
+		return false
+	}
+	return true
+}
@@ -1,8 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
@@ -10,7 +21,10 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterHandler(s *storage.PostgresStorage) http.HandlerFunc {
+// Логин: 3-32 символа, латинские буквы, цифры, '_', '.', '-'; начинается с буквы или цифры
+var loginRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{2,31}$`)
+
+func RegisterHandler(s *storage.PostgresStorage, policy *auth.PasswordPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.RegisterRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -18,6 +32,20 @@ func RegisterHandler(s *storage.PostgresStorage) http.HandlerFunc {
 			return
 		}
 
+		if !loginRe.MatchString(req.Login) {
+			WriteError(w, http.StatusBadRequest, CodeInvalidLogin,
+				"Login must be 3-32 characters: latin letters, digits, '_', '.' or '-', starting with a letter or digit")
+			return
+		}
+		if err := policy.Check(req.Login, req.Password); err != nil {
+			code := CodeWeakPassword
+			if errors.Is(err, auth.ErrPasswordBreached) {
+				code = CodeBreachedPassword
+			}
+			WriteError(w, http.StatusBadRequest, code, "Password rejected: "+err.Error())
+			return
+		}
+
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -30,7 +58,12 @@ func RegisterHandler(s *storage.PostgresStorage) http.HandlerFunc {
 		}
 
 		if err := s.CreateUser(r.Context(), &user); err != nil {
-			respondWithError(w, http.StatusConflict, "User already exists")
+			if errors.Is(err, storage.ErrLoginTaken) {
+				WriteError(w, http.StatusConflict, CodeLoginTaken, "User already exists")
+				return
+			}
+			log.Printf("Failed to create user: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
@@ -38,31 +71,203 @@ func RegisterHandler(s *storage.PostgresStorage) http.HandlerFunc {
 	}
 }
 
-func LoginHandler(s *storage.PostgresStorage) http.HandlerFunc {
+// LoginHandler проверяет логин и пароль. Неудачные попытки считаются по логину и по IP
+// (см. models.LoginProtection); ответы для существующих и несуществующих логинов не различаются.
+func LoginHandler(s *storage.PostgresStorage, protection models.LoginProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.LoginRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Login) > maxLoginKeyLength {
 			respondWithError(w, http.StatusBadRequest, "Invalid request")
 			return
 		}
+		ip := clientIP(r)
+
+		retryAfter, err := s.BeginLoginAttempt(r.Context(), req.Login, ip, protection)
+		if err != nil {
+			log.Printf("Failed to check login attempts: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if retryAfter > 0 {
+			recordFailedLogin(s, req.Login, ip, "locked")
+			setRetryAfter(w, retryAfter)
+			WriteError(w, http.StatusTooManyRequests, CodeLoginLocked, "Too many failed login attempts, try again later")
+			return
+		}
 
 		user, err := s.GetUserByLogin(r.Context(), req.Login)
 		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to get user: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			// сравниваем с подставным хэшем, чтобы по времени ответа нельзя было понять, что логина нет
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+			recordFailedLogin(s, req.Login, ip, "unknown_login")
+			WriteError(w, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
 			return
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-			respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+			recordFailedLogin(s, req.Login, ip, "wrong_password")
+			WriteError(w, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+
+		if err := s.FinishLoginAttempt(r.Context(), req.Login, ip, protection); err != nil {
+			log.Printf("Failed to reset login attempts of user %d: %v", user.ID, err)
+		}
+
+		resp, err := issueSession(r.Context(), s, user)
+		if err != nil {
+			log.Printf("Failed to issue tokens for user %d: %v", user.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// Длиннее логины не хранятся в счётчиках попыток; зарегистрировать такой логин всё равно нельзя
+const maxLoginKeyLength = 255
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func recordFailedLogin(s *storage.PostgresStorage, login, ip, reason string) {
+	if err := s.RecordFailedLogin(context.Background(), login, ip, reason); err != nil {
+		log.Printf("Failed to record failed login: %v", err)
+	}
+}
+
+// clientIP — адрес клиента из соединения. X-Forwarded-For не учитывается: его может подделать кто угодно.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RefreshHandler: POST /api/v1/token/refresh обменивает refresh-токен на новую пару токенов.
+// Каждый refresh-токен действует один раз; повторное предъявление отзывает всю цепочку.
+func RefreshHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req models.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid request")
 			return
 		}
 
-		token, err := auth.GenerateToken(user.ID)
+		refreshToken, err := auth.NewAPIKey(auth.RefreshTokenPrefix)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, models.LoginResponse{Token: token})
+		userID, err := s.RotateRefreshToken(r.Context(), auth.HashAPIKey(req.RefreshToken),
+			auth.HashAPIKey(refreshToken), time.Now().Add(auth.RefreshTokenExpiration))
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrRefreshTokenReused):
+				log.Printf("Refresh token reuse detected, session revoked")
+				WriteError(w, http.StatusUnauthorized, CodeRefreshTokenReused, "Refresh token reuse detected, please log in again")
+			case errors.Is(err, sql.ErrNoRows):
+				respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			default:
+				log.Printf("Failed to refresh token: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		// роль и версия сессий берутся из БД: изменения роли применяются при следующем обновлении токена
+		user, err := s.GetUserByID(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		token, err := auth.GenerateToken(user.ID, user.Role, user.SessionVersion)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.LoginResponse{
+			Token:        token,
+			RefreshToken: refreshToken,
+			ExpiresIn:    int(auth.TokenExpiration.Seconds()),
+		})
 	}
 }
+
+// LogoutHandler: POST /api/v1/logout отзывает токен доступа, с которым пришёл запрос,
+// и цепочку refresh-токенов, если refresh-токен передан в теле.
+func LogoutHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req models.LogoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			respondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		claims, ok := r.Context().Value("claims").(*auth.Claims)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Logout requires a token; revoke API keys via /api/v1/keys")
+			return
+		}
+		if err := s.RevokeToken(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			log.Printf("Failed to log out user %d: %v", claims.UserID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if req.RefreshToken != "" {
+			if err := s.RevokeRefreshTokenFamily(r.Context(), claims.UserID, auth.HashAPIKey(req.RefreshToken)); err != nil {
+				log.Printf("Failed to log out user %d: %v", claims.UserID, err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+	}
+}
+
+// issueSession начинает новую сессию пользователя: токен доступа и первый refresh-токен цепочки
+func issueSession(ctx context.Context, s *storage.PostgresStorage, user *models.User) (*models.LoginResponse, error) {
+	token, err := auth.GenerateToken(user.ID, user.Role, user.SessionVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.NewAPIKey(auth.RefreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.CreateRefreshToken(ctx, user.ID, uuid.NewString(), auth.HashAPIKey(refreshToken),
+		time.Now().Add(auth.RefreshTokenExpiration)); err != nil {
+		return nil, err
+	}
+
+	return &models.LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.TokenExpiration.Seconds()),
+	}, nil
+}
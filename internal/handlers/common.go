@@ -3,12 +3,41 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
+// ErrorResponse — единый формат ошибок API: сообщение для человека и код для программ
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// Коды ошибок, которые клиенту нужно различать при одинаковом HTTP-статусе.
+// Остальные ошибки получают код по статусу: not_found, unauthorized и т. п.
+const (
+	CodeInvalidLogin       = "invalid_login"
+	CodeLoginTaken         = "login_taken"
+	CodeWeakPassword       = "weak_password"
+	CodeBreachedPassword   = "breached_password"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeLoginLocked        = "login_locked"
+	CodeTokenRevoked       = "token_revoked"
+	CodeRefreshTokenReused = "refresh_token_reused"
+	CodeOrgNameTaken       = "org_name_taken"
+	CodeAlreadyMember      = "already_member"
+	CodeLastOwner          = "last_owner"
+)
+
+// WriteError пишет ошибку в едином формате. Пустой code заменяется кодом по статусу.
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	respondWithJSON(w, status, ErrorResponse{Error: message, Code: code})
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	WriteError(w, code, "", message)
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,7 +25,7 @@ var (
 	ErrDivisionByZero = errors.New("division by zero")
 )
 
-func ExpressionHandler(s *storage.PostgresStorage) http.HandlerFunc {
+func ExpressionHandler(s *storage.PostgresStorage, limits models.SubmissionLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("user_id").(int)
 
@@ -34,19 +35,75 @@ func ExpressionHandler(s *storage.PostgresStorage) http.HandlerFunc {
 			return
 		}
 
+		priority := models.DefaultPriority
+		if exprReq.Priority != nil {
+			priority = *exprReq.Priority
+		}
+		if priority < models.MinPriority || priority > models.MaxPriority {
+			respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Priority must be between %d and %d", models.MinPriority, models.MaxPriority))
+			return
+		}
+
+		// отправлять выражения от имени организации могут участники с ролью member и выше
+		if exprReq.OrgID != nil {
+			role, err := s.GetMemberRole(r.Context(), *exprReq.OrgID, userID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to get role of user %d in organization %d: %v", userID, *exprReq.OrgID, err)
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if !models.OrgRoleAtLeast(role, models.OrgRoleMember) {
+				respondWithError(w, http.StatusForbidden, "Not allowed to submit expressions to this organization")
+				return
+			}
+		}
+
+		effective, counted, limitErr, err := checkSubmissionLimits(r.Context(), s, userID, exprReq.Expression, limits)
+		if err != nil {
+			log.Printf("Failed to check limits for user %d: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to check limits")
+			return
+		}
+		if limitErr != nil {
+			respondWithLimitError(w, limitErr)
+			return
+		}
+
 		expr := models.Expression{
 			UserID:     userID,
 			Expression: exprReq.Expression,
 			Status:     "pending",
+			Priority:   priority,
+			OrgID:      exprReq.OrgID,
+		}
+
+		// непринятое выражение не должно расходовать минутный лимит
+		releaseSubmission := func() {
+			if !counted {
+				return
+			}
+			if err := s.ReleaseSubmissionSlot(r.Context(), userID); err != nil {
+				log.Printf("Failed to release submission slot of user %d: %v", userID, err)
+			}
 		}
 
-		if err := s.CreateExpression(r.Context(), &expr); err != nil {
+		created, err := s.CreateExpressionWithinPending(r.Context(), &expr, effective.MaxPending)
+		if err != nil {
+			releaseSubmission()
+			log.Printf("Failed to create expression for user %d: %v", userID, err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to create expression")
 			return
 		}
+		if !created {
+			releaseSubmission()
+			respondWithLimitError(w, pendingLimitError(effective.MaxPending))
+			return
+		}
 
 		if err := CreateTasksFromExpression(s, &expr); err != nil {
 			_ = s.DeleteExpression(r.Context(), expr.ID)
+			releaseSubmission()
 
 			var status int
 			var msg string
@@ -147,6 +204,12 @@ func CreateTasksFromExpression(s *storage.PostgresStorage, expr *models.Expressi
 		return err
 	}
 
+	// время операций фиксируется в задаче, поэтому последующие изменения не затронут это выражение
+	operationTimes, err := CurrentOperationTimes(context.Background(), s)
+	if err != nil {
+		return fmt.Errorf("failed to get operation times: %w", err)
+	}
+
 	var taskStack []string
 	for _, token := range rpnTokens {
 		if IsNum(token) {
@@ -181,9 +244,10 @@ func CreateTasksFromExpression(s *storage.PostgresStorage, expr *models.Expressi
 				Arg1:          arg1,
 				Arg2:          arg2,
 				Operation:     token,
-				OperationTime: GetOperationTime(token),
+				OperationTime: operationTimes[token],
 				Status:        "pending",
 				DependsOn:     dependsOn,
+				Priority:      expr.Priority,
 			}
 
 			// Сохраняем задачу в БД
@@ -224,22 +288,29 @@ func CreateTasksFromExpression(s *storage.PostgresStorage, expr *models.Expressi
 	return nil
 }
 
-func GetOperationTime(op string) int {
-	switch op {
-	case "+", "-":
-		return 1000
-	case "*", "/":
-		return 2000
-	default:
-		return 1000
-	}
-}
-
+// GetExpressionsHandler: GET /api/v1/expressions — выражения пользователя,
+// ?org_id= — выражения организации, в которой он состоит
 func GetExpressionsHandler(s *storage.PostgresStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("user_id").(int)
 
-		expressions, err := s.GetUserExpressions(r.Context(), userID)
+		var (
+			expressions []models.Expression
+			err         error
+		)
+		if orgStr := r.URL.Query().Get("org_id"); orgStr != "" {
+			orgID, convErr := strconv.Atoi(orgStr)
+			if convErr != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+				return
+			}
+			if _, ok := memberRole(w, r, s, orgID, userID); !ok {
+				return
+			}
+			expressions, err = s.GetOrgExpressions(r.Context(), orgID)
+		} else {
+			expressions, err = s.GetUserExpressions(r.Context(), userID)
+		}
 		if err != nil {
 			log.Printf("DB error: %v", err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to get expressions")
@@ -266,7 +337,13 @@ func GetExpressionByIDHandler(s *storage.PostgresStorage) http.HandlerFunc {
 			return
 		}
 
-		if expr.UserID != userID {
+		allowed, err := s.CanViewExpression(r.Context(), userID, expr)
+		if err != nil {
+			log.Printf("Failed to check access to expression %d: %v", id, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if !allowed {
 			respondWithError(w, http.StatusForbidden, "Access denied")
 			return
 		}
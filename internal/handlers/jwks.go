@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+)
+
+// JWKSHandler: GET /.well-known/jwks.json — открытые ключи RS256/EdDSA, которыми другие
+// сервисы проверяют токены калькулятора.
+func JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// ключи меняются редко, но после ротации клиенты должны увидеть новый ключ быстро
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondWithJSON(w, http.StatusOK, auth.CurrentJWKS())
+	}
+}
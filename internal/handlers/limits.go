@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// Через сколько предлагать повторить отправку, если у пользователя слишком много выражений в работе
+const pendingRetryAfter = 5 * time.Second
+
+// limitError — превышенный лимит. retryAfter задаётся для временных ограничений (429).
+type limitError struct {
+	status     int
+	message    string
+	retryAfter time.Duration
+}
+
+func effectiveLimits(defaults models.SubmissionLimits, o *models.LimitOverrides) models.SubmissionLimits {
+	limits := defaults
+	if o.SubmissionsPerMinute != nil {
+		limits.SubmissionsPerMinute = *o.SubmissionsPerMinute
+	}
+	if o.MaxPending != nil {
+		limits.MaxPending = *o.MaxPending
+	}
+	if o.MaxTasksPerExpression != nil {
+		limits.MaxTasksPerExpression = *o.MaxTasksPerExpression
+	}
+	if o.MaxExpressionLength != nil {
+		limits.MaxExpressionLength = *o.MaxExpressionLength
+	}
+	return limits
+}
+
+// checkSubmissionLimits проверяет, можно ли принять выражение, и возвращает действующие лимиты пользователя.
+// Минутный лимит проверяется последним и учитывает только прошедшие остальные проверки отправки:
+// true означает, что отправка учтена, и если выражение всё же не будет принято, её нужно вернуть
+// через ReleaseSubmissionSlot. Лимит выражений в работе проверяется при создании выражения
+// (CreateExpressionWithinPending), чтобы параллельные отправки не обошли его.
+func checkSubmissionLimits(ctx context.Context, s *storage.PostgresStorage, userID int, expression string, defaults models.SubmissionLimits) (models.SubmissionLimits, bool, *limitError, error) {
+	overrides, err := s.GetLimitOverrides(ctx, userID)
+	if err != nil {
+		return defaults, false, nil, err
+	}
+	limits := effectiveLimits(defaults, overrides)
+
+	if limits.MaxExpressionLength > 0 && len(expression) > limits.MaxExpressionLength {
+		return limits, false, &limitError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("Expression is too long: limit is %d characters", limits.MaxExpressionLength),
+		}, nil
+	}
+
+	if limits.MaxTasksPerExpression > 0 {
+		// ошибку разбора сообщит CreateTasksFromExpression, здесь только считаем операции
+		if rpnTokens, err := InfixToRPN(expression); err == nil {
+			operations := 0
+			for _, token := range rpnTokens {
+				if IsOperation(token) {
+					operations++
+				}
+			}
+			if operations > limits.MaxTasksPerExpression {
+				return limits, false, &limitError{
+					status:  http.StatusUnprocessableEntity,
+					message: fmt.Sprintf("Expression has too many operations: limit is %d", limits.MaxTasksPerExpression),
+				}, nil
+			}
+		}
+	}
+
+	if limits.SubmissionsPerMinute > 0 {
+		ok, remaining, err := s.TakeSubmissionSlot(ctx, userID, limits.SubmissionsPerMinute)
+		if err != nil {
+			return limits, false, nil, err
+		}
+		if !ok {
+			return limits, false, &limitError{
+				status:     http.StatusTooManyRequests,
+				message:    fmt.Sprintf("Rate limit exceeded: limit is %d expressions per minute", limits.SubmissionsPerMinute),
+				retryAfter: remaining,
+			}, nil
+		}
+		return limits, true, nil, nil
+	}
+
+	return limits, false, nil, nil
+}
+
+func pendingLimitError(maxPending int) *limitError {
+	return &limitError{
+		status:     http.StatusTooManyRequests,
+		message:    fmt.Sprintf("Too many expressions in progress: limit is %d", maxPending),
+		retryAfter: pendingRetryAfter,
+	}
+}
+
+func respondWithLimitError(w http.ResponseWriter, e *limitError) {
+	if e.retryAfter > 0 {
+		setRetryAfter(w, e.retryAfter)
+	}
+	respondWithError(w, e.status, e.message)
+}
+
+// setRetryAfter сообщает клиенту, через сколько секунд (не меньше одной) повторить запрос
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := max(int(math.Ceil(d.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func UserLimitsHandler(s *storage.PostgresStorage, defaults models.SubmissionLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.URL.Path[len("/api/v1/admin/limits/"):])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var overrides models.LimitOverrides
+			if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request")
+				return
+			}
+			for _, v := range []*int{overrides.SubmissionsPerMinute, overrides.MaxPending, overrides.MaxTasksPerExpression, overrides.MaxExpressionLength} {
+				if v != nil && *v < 0 {
+					respondWithError(w, http.StatusBadRequest, "Limits must not be negative")
+					return
+				}
+			}
+
+			if err := s.SetLimitOverrides(r.Context(), userID, &overrides); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					respondWithError(w, http.StatusNotFound, "User not found")
+					return
+				}
+				log.Printf("Failed to set limits for user %d: %v", userID, err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to set limits")
+				return
+			}
+			log.Printf("Limits for user %d updated", userID)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		overrides, err := s.GetLimitOverrides(r.Context(), userID)
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get limits")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"user_id":   userID,
+			"defaults":  defaults,
+			"overrides": overrides,
+			"effective": effectiveLimits(defaults, overrides),
+		})
+	}
+}
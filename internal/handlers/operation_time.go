@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// Время выполнения операций в миллисекундах, если оно не задано через админский API
+var (
+	operationTimesMu      sync.RWMutex
+	defaultOperationTimes = map[string]int{
+		"+": 1000,
+		"-": 1000,
+		"*": 2000,
+		"/": 2000,
+	}
+)
+
+func SetDefaultOperationTimes(times map[string]int) {
+	operationTimesMu.Lock()
+	defer operationTimesMu.Unlock()
+	maps.Copy(defaultOperationTimes, times)
+}
+
+func GetOperationTime(op string) int {
+	operationTimesMu.RLock()
+	defer operationTimesMu.RUnlock()
+	if ms, ok := defaultOperationTimes[op]; ok {
+		return ms
+	}
+	return 1000
+}
+
+// CurrentOperationTimes возвращает время операций с учётом значений, заданных через админский API.
+// Значения хранятся в базе, поэтому изменения сразу видны всем репликам.
+func CurrentOperationTimes(ctx context.Context, s *storage.PostgresStorage) (map[string]int, error) {
+	overrides, err := s.GetOperationTimeOverrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	operationTimesMu.RLock()
+	times := maps.Clone(defaultOperationTimes)
+	operationTimesMu.RUnlock()
+
+	for op, ms := range overrides {
+		times[op] = ms
+	}
+	return times, nil
+}
+
+func OperationTimesHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req map[string]int
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request")
+				return
+			}
+			for op, ms := range req {
+				if !IsOperation(op) {
+					respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown operation %q", op))
+					return
+				}
+				if ms < 0 {
+					respondWithError(w, http.StatusBadRequest, "Operation time must not be negative")
+					return
+				}
+			}
+
+			if err := s.SetOperationTimeOverrides(r.Context(), req); err != nil {
+				log.Printf("Failed to set operation times: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to set operation times")
+				return
+			}
+			log.Printf("Operation times updated: %v", req)
+		case http.MethodDelete:
+			// возврат к значениям из переменных среды
+			if err := s.ResetOperationTimeOverrides(r.Context()); err != nil {
+				log.Printf("Failed to reset operation times: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to reset operation times")
+				return
+			}
+			log.Println("Operation times reset to defaults")
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		times, err := CurrentOperationTimes(r.Context(), s)
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get operation times")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, times)
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// OrganizationsHandler: GET /api/v1/orgs — организации пользователя с его ролью,
+// POST — создание организации, создатель становится её владельцем.
+func OrganizationsHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+
+		switch r.Method {
+		case http.MethodGet:
+			orgs, err := s.ListUserOrganizations(r.Context(), userID)
+			if err != nil {
+				log.Printf("DB error: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to get organizations")
+				return
+			}
+			if orgs == nil {
+				orgs = []models.Organization{}
+			}
+			respondWithJSON(w, http.StatusOK, orgs)
+		case http.MethodPost:
+			if !requireTokenSession(w, r) {
+				return
+			}
+			createOrganization(w, r, s, userID)
+		default:
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func createOrganization(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, userID int) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1-64 characters")
+		return
+	}
+
+	org := models.Organization{Name: req.Name}
+	if err := s.CreateOrganization(r.Context(), &org, userID); err != nil {
+		if errors.Is(err, storage.ErrOrgNameTaken) {
+			WriteError(w, http.StatusConflict, CodeOrgNameTaken, "Organization name is already taken")
+			return
+		}
+		log.Printf("Failed to create organization for user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create organization")
+		return
+	}
+
+	log.Printf("User %d created organization %d", userID, org.ID)
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+// OrganizationHandler обслуживает /api/v1/orgs/{id}/...:
+// GET /{id} — организация с участниками, DELETE /{id} — удаление (owner),
+// POST /{id}/members — добавление участника по логину (admin),
+// PUT и DELETE /{id}/members/{user_id} — смена роли и исключение (admin; выйти сам может любой).
+// Чужим организациям отвечаем 404, чтобы не раскрывать их существование.
+func OrganizationHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+
+		idStr, rest, _ := strings.Cut(r.URL.Path[len("/api/v1/orgs/"):], "/")
+		orgID, err := strconv.Atoi(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+			return
+		}
+		action, memberStr, hasMember := strings.Cut(rest, "/")
+		memberID := 0
+		if hasMember {
+			if memberID, err = strconv.Atoi(memberStr); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+				return
+			}
+		}
+		if r.Method != http.MethodGet && !requireTokenSession(w, r) {
+			return
+		}
+
+		role, ok := memberRole(w, r, s, orgID, userID)
+		if !ok {
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			getOrganization(w, r, s, orgID, role)
+		case action == "" && r.Method == http.MethodDelete:
+			deleteOrganization(w, r, s, orgID, userID, role)
+		case action == "members" && !hasMember && r.Method == http.MethodPost:
+			addMember(w, r, s, orgID, role)
+		case action == "members" && hasMember && r.Method == http.MethodPut:
+			setMemberRole(w, r, s, orgID, role, memberID)
+		case action == "members" && hasMember && r.Method == http.MethodDelete:
+			removeMember(w, r, s, orgID, userID, role, memberID)
+		case action == "" || action == "members":
+			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		default:
+			respondWithError(w, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+// memberRole возвращает роль пользователя в организации. Если он в ней не состоит,
+// отвечает 404 и возвращает false.
+func memberRole(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID, userID int) (string, bool) {
+	role, err := s.GetMemberRole(r.Context(), orgID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Organization not found")
+		} else {
+			log.Printf("Failed to get role of user %d in organization %d: %v", userID, orgID, err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return "", false
+	}
+	return role, true
+}
+
+func getOrganization(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID int, role string) {
+	org, err := s.GetOrganization(r.Context(), orgID)
+	if err != nil {
+		log.Printf("Failed to get organization %d: %v", orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get organization")
+		return
+	}
+	org.Role = role
+
+	members, err := s.ListMembers(r.Context(), orgID)
+	if err != nil {
+		log.Printf("Failed to get members of organization %d: %v", orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get organization")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		*models.Organization
+		Members []models.OrgMember `json:"members"`
+	}{org, members})
+}
+
+func deleteOrganization(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID, userID int, role string) {
+	if role != models.OrgRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can delete the organization")
+		return
+	}
+	if err := s.DeleteOrganization(r.Context(), orgID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to delete organization %d: %v", orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete organization")
+		return
+	}
+
+	log.Printf("User %d deleted organization %d", userID, orgID)
+	respondWithJSON(w, http.StatusOK, map[string]int{"id": orgID})
+}
+
+func addMember(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID int, role string) {
+	var req struct {
+		Login string `json:"login"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	if !canGrantOrgRole(w, role, req.Role) {
+		return
+	}
+
+	member, err := s.AddMember(r.Context(), orgID, req.Login, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			respondWithError(w, http.StatusNotFound, "User not found")
+		case errors.Is(err, storage.ErrAlreadyMember):
+			WriteError(w, http.StatusConflict, CodeAlreadyMember, "User is already a member of the organization")
+		default:
+			log.Printf("Failed to add member to organization %d: %v", orgID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to add member")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, member)
+}
+
+func setMemberRole(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID int, role string, memberID int) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if !canGrantOrgRole(w, role, req.Role) || !canManageMember(w, r, s, orgID, role, memberID) {
+		return
+	}
+
+	if err := s.SetMemberRole(r.Context(), orgID, memberID, req.Role); err != nil {
+		respondWithMemberError(w, orgID, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]any{"user_id": memberID, "role": req.Role})
+}
+
+func removeMember(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID, userID int, role string, memberID int) {
+	// выйти из организации может любой участник
+	if memberID != userID && !canManageMember(w, r, s, orgID, role, memberID) {
+		return
+	}
+
+	if err := s.RemoveMember(r.Context(), orgID, memberID); err != nil {
+		respondWithMemberError(w, orgID, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]int{"user_id": memberID})
+}
+
+// canGrantOrgRole проверяет, что участник с ролью role может выдать роль granted:
+// участниками управляют admin и owner, владельцев назначает только owner.
+func canGrantOrgRole(w http.ResponseWriter, role, granted string) bool {
+	if !models.IsOrgRole(granted) {
+		respondWithError(w, http.StatusBadRequest, "Role must be viewer, member, admin or owner")
+		return false
+	}
+	if !models.OrgRoleAtLeast(role, models.OrgRoleAdmin) {
+		respondWithError(w, http.StatusForbidden, "Only admins and owners can manage members")
+		return false
+	}
+	if granted == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can appoint owners")
+		return false
+	}
+	return true
+}
+
+// canManageMember проверяет, что участник с ролью role может менять участника memberID:
+// admin управляет всеми, кроме владельцев
+func canManageMember(w http.ResponseWriter, r *http.Request, s *storage.PostgresStorage, orgID int, role string, memberID int) bool {
+	if !models.OrgRoleAtLeast(role, models.OrgRoleAdmin) {
+		respondWithError(w, http.StatusForbidden, "Only admins and owners can manage members")
+		return false
+	}
+
+	target, err := s.GetMemberRole(r.Context(), orgID, memberID)
+	if err != nil {
+		respondWithMemberError(w, orgID, err)
+		return false
+	}
+	if target == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can manage owners")
+		return false
+	}
+	return true
+}
+
+func respondWithMemberError(w http.ResponseWriter, orgID int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, http.StatusNotFound, "Member not found")
+	case errors.Is(err, storage.ErrLastOwner):
+		WriteError(w, http.StatusConflict, CodeLastOwner, "Organization must keep at least one owner")
+	default:
+		log.Printf("Failed to change member of organization %d: %v", orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to change member")
+	}
+}
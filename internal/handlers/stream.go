@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+const (
+	maxStreamConcurrency = 64
+	// Агент шлёт heartbeat чаще, иначе соединение считается потерянным
+	streamHeartbeatTimeout = 30 * time.Second
+	streamWriteTimeout     = 10 * time.Second
+	// Перепроверяем очередь, даже если уведомление не пришло
+	streamRecheckInterval = 30 * time.Second
+)
+
+var streamUpgrader = websocket.Upgrader{}
+
+// Открытые стримы агентов. Соединения стримов не учитываются в http.Server.Shutdown,
+// поэтому оркестратор ждёт их отдельно через WaitAgentStreams.
+var agentStreams sync.WaitGroup
+
+// WaitAgentStreams ждёт, пока все стримы агентов закроются и вернут невыполненные задачи в очередь.
+func WaitAgentStreams(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		agentStreams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TaskStream выдаёт задачи одному подключённому агенту, не больше concurrency одновременно,
+// и помнит выданные, чтобы вернуть их в очередь, если агент отключится.
+type TaskStream struct {
+	store *storage.PostgresStorage
+	agent *models.Agent
+	// ключ, которым аутентифицирован агент стрима
+	agentKeyID int
+
+	mu       sync.Mutex
+	inflight map[string]*models.Task
+	slots    chan struct{}
+}
+
+// NewTaskStream создаёт стрим для подключившегося агента. После отключения агента
+// нужно вызвать Close.
+func NewTaskStream(s *storage.PostgresStorage, concurrency int, agent *models.Agent, agentKeyID int) *TaskStream {
+	agentStreams.Add(1)
+	return &TaskStream{
+		store:      s,
+		agent:      agent,
+		agentKeyID: agentKeyID,
+		inflight:   make(map[string]*models.Task),
+		slots:      make(chan struct{}, min(max(concurrency, 1), maxStreamConcurrency)),
+	}
+}
+
+func (t *TaskStream) Concurrency() int {
+	return cap(t.slots)
+}
+
+// Heartbeat отмечает в реестре, что агент стрима жив.
+func (t *TaskStream) Heartbeat(ctx context.Context) {
+	if _, err := t.store.TouchAgent(ctx, t.agent.ID, t.agentKeyID); err != nil {
+		log.Printf("Failed to record heartbeat of agent %s: %v", t.agent.ID, err)
+	}
+}
+
+// Dispatch отправляет агенту задачи по мере появления, пока не отменён ctx или send не вернёт ошибку.
+// Возвращает storage.ErrShuttingDown, если оркестратор останавливается.
+func (t *TaskStream) Dispatch(ctx context.Context, send func(*models.Task) error) error {
+	for {
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.store.DispatchStopped():
+			return storage.ErrShuttingDown
+		}
+
+		var task *models.Task
+		for task == nil {
+			var err error
+			task, err = t.store.WaitNextTaskFromQueue(ctx, streamRecheckInterval, t.agent.ID, AgentOperations(t.agent))
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, storage.ErrShuttingDown) {
+					log.Printf("Failed to get task for agent stream: %v", err)
+				}
+				return err
+			}
+		}
+
+		t.mu.Lock()
+		t.inflight[task.ID] = task
+		t.mu.Unlock()
+
+		if err := send(task); err != nil {
+			log.Printf("Failed to push task %s to agent: %v", task.ID, err)
+			return err
+		}
+		log.Printf("Pushed task %s to agent stream", task.ID)
+	}
+}
+
+// HandleResult обрабатывает ответ агента по выданной задаче: completed с результатом
+// завершает её, failed отмечает невычислимой, всё остальное возвращает задачу в очередь.
+func (t *TaskStream) HandleResult(taskID, status string, result *float64) {
+	t.mu.Lock()
+	task, ok := t.inflight[taskID]
+	delete(t.inflight, taskID)
+	t.mu.Unlock()
+	if !ok {
+		log.Printf("Agent stream reported unknown task %s", taskID)
+		return
+	}
+	defer func() { <-t.slots }()
+
+	// результат сохраняем и после обрыва соединения, поэтому контекст не привязан к стриму
+	ctx := context.Background()
+	if status == "completed" && result != nil {
+		err := CompleteTask(ctx, t.store, task, t.agent.ID, *result)
+		if err == nil {
+			RecordAgentTaskCompleted(t.store, t.agent.ID, t.agentKeyID)
+			return
+		}
+		if errors.Is(err, storage.ErrTaskNotAssigned) {
+			log.Printf("Agent %s reported task %s that is not assigned to it", t.agent.ID, task.ID)
+			return
+		}
+		log.Printf("Failed to complete task %s: %v", task.ID, err)
+	}
+	if status == "failed" {
+		if err := t.store.FailTask(ctx, task.ID, t.agent.ID); err != nil {
+			log.Printf("Failed to fail task %s: %v", task.ID, err)
+			return
+		}
+		log.Printf("Task %s failed, expression %d marked as failed", task.ID, task.ExpressionID)
+		return
+	}
+
+	if err := t.store.RequeueTask(ctx, task.ID, t.agent.ID); err != nil {
+		log.Printf("Failed to requeue task %s: %v", task.ID, err)
+		return
+	}
+	log.Printf("Task %s requeued successfully", task.ID)
+}
+
+// RequeueInflight возвращает в очередь все задачи, по которым агент так и не ответил.
+func (t *TaskStream) RequeueInflight() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id := range t.inflight {
+		if err := t.store.RequeueTask(context.Background(), id, t.agent.ID); err != nil {
+			log.Printf("Failed to requeue task %s after agent disconnect: %v", id, err)
+			continue
+		}
+		log.Printf("Task %s requeued after agent disconnect", id)
+	}
+	t.inflight = make(map[string]*models.Task)
+}
+
+// Close возвращает в очередь невыполненные задачи и отмечает стрим закрытым.
+func (t *TaskStream) Close() {
+	t.RequeueInflight()
+	agentStreams.Done()
+}
+
+func AgentStreamHandler(s *storage.PostgresStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agent, ok := requestAgent(w, r, s)
+		if !ok {
+			return
+		}
+
+		conn, err := streamUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Failed to upgrade agent stream: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(streamHeartbeatTimeout))
+		var hello models.StreamMessage
+		if err := conn.ReadJSON(&hello); err != nil || hello.Type != "hello" {
+			log.Printf("Agent stream closed before hello: %v", err)
+			return
+		}
+
+		stream := NewTaskStream(s, hello.Concurrency, agent, AgentKeyID(r.Context()))
+		log.Printf("Agent stream opened from %s with concurrency %d", r.RemoteAddr, stream.Concurrency())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		readerDone := make(chan struct{})
+		go func() {
+			defer close(readerDone)
+			defer cancel()
+			for {
+				var msg models.StreamMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					log.Printf("Agent stream read error: %v", err)
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(streamHeartbeatTimeout))
+
+				switch msg.Type {
+				case "heartbeat":
+					stream.Heartbeat(ctx)
+				case "result":
+					stream.HandleResult(msg.TaskID, msg.Status, msg.Result)
+				default:
+					log.Printf("Unknown agent stream message type: %q", msg.Type)
+				}
+			}
+		}()
+
+		err = stream.Dispatch(ctx, func(task *models.Task) error {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			return conn.WriteJSON(models.StreamMessage{Type: "task", Task: task})
+		})
+		if errors.Is(err, storage.ErrShuttingDown) {
+			// агент переподключится, уже к другой реплике
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server is shutting down, retry elsewhere"),
+				time.Now().Add(streamWriteTimeout))
+		}
+		cancel()
+		conn.Close()
+		<-readerDone
+
+		// агент отключился: всё, что он не успел посчитать, возвращаем в очередь
+		stream.Close()
+		log.Printf("Agent stream from %s closed", r.RemoteAddr)
+	}
+}
@@ -1,20 +1,52 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
 )
 
+// Максимальное время, на которое агент может подвесить запрос за задачей
+const MaxTaskWait = 60 * time.Second
+
 func GetTaskHandler(s *storage.PostgresStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("GetTaskHandler called")
 
-		task, err := s.GetNextTaskFromQueue(r.Context())
+		var wait time.Duration
+		if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+			d, err := time.ParseDuration(waitStr)
+			if err != nil || d < 0 {
+				respondWithError(w, http.StatusBadRequest, "Invalid wait duration")
+				return
+			}
+			wait = min(d, MaxTaskWait)
+		}
+
+		agent, ok := requestAgent(w, r, s)
+		if !ok {
+			return
+		}
+
+		task, err := s.WaitNextTaskFromQueue(r.Context(), wait, agent.ID, AgentOperations(agent))
 		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			if errors.Is(err, storage.ErrShuttingDown) {
+				// агент повторит запрос и попадёт на другую реплику
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusServiceUnavailable, "Server is shutting down, retry elsewhere")
+				return
+			}
 			log.Printf("Database error: %v", err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to get task")
 			return
@@ -38,6 +70,9 @@ func GetTaskByIDHandler(s *storage.PostgresStorage) http.HandlerFunc {
 			respondWithError(w, http.StatusBadRequest, "Task ID is required")
 			return
 		}
+		if _, ok := requestAgent(w, r, s); !ok {
+			return
+		}
 
 		task, err := s.GetTaskByID(r.Context(), taskID)
 		if err != nil {
@@ -64,8 +99,17 @@ func RequeueTaskHandler(s *storage.PostgresStorage) http.HandlerFunc {
 
 		log.Printf("RequeueTaskHandler: task %s, status %s", req.ID, req.Status)
 
+		agent, ok := requestAgent(w, r, s)
+		if !ok {
+			return
+		}
+
 		task, err := s.GetTaskByID(r.Context(), req.ID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Task not found")
+				return
+			}
 			log.Printf("Failed to get task %s: %v", req.ID, err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to get task")
 			return
@@ -78,92 +122,113 @@ func RequeueTaskHandler(s *storage.PostgresStorage) http.HandlerFunc {
 				return
 			}
 
-			if err := s.UpdateTaskResult(r.Context(), req.ID, *req.Result); err != nil {
-				log.Printf("Failed to update task %s: %v", req.ID, err)
-				respondWithError(w, http.StatusInternalServerError, "Failed to update task")
-				return
+			err = CompleteTask(r.Context(), s, task, agent.ID, *req.Result)
+			if err == nil {
+				RecordAgentTaskCompleted(s, agent.ID, AgentKeyID(r.Context()))
 			}
-
-			tasks, err := s.GetTasksByExpressionID(r.Context(), task.ExpressionID)
-			if err != nil {
-				log.Printf("Failed to get tasks for expression %d: %v", task.ExpressionID, err)
-				respondWithError(w, http.StatusInternalServerError, "Failed to get tasks")
-				return
+		} else if req.Status == "pending" {
+			err = s.RequeueTask(r.Context(), req.ID, agent.ID)
+			if err == nil {
+				log.Printf("Task %s requeued successfully", req.ID)
 			}
-
-			allCompleted := true
-			var finalResult float64
-			taskMap := make(map[string]*models.Task)
-			for _, t := range tasks {
-				taskMap[t.ID] = t
-				if t.Status != "completed" {
-					allCompleted = false
-				}
+		} else if req.Status == "failed" {
+			err = s.FailTask(r.Context(), req.ID, agent.ID)
+			if err == nil {
+				log.Printf("Task %s failed, expression %d marked as failed", req.ID, task.ExpressionID)
 			}
+		}
+		if errors.Is(err, storage.ErrTaskNotAssigned) {
+			// задачу уже посчитали, вернули в очередь или выдали другому агенту
+			log.Printf("Agent %s reported task %s that is not assigned to it", agent.ID, req.ID)
+			respondWithError(w, http.StatusConflict, "Task is not assigned to this agent")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to process %s result of task %s: %v", req.Status, req.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to update task")
+			return
+		}
 
-			if allCompleted {
-				var rootTask *models.Task
-				for _, t := range tasks {
-					isRoot := true
-					for _, other := range tasks {
-						for _, dep := range other.DependsOn {
-							if dep == t.ID {
-								isRoot = false
-								break
-							}
-						}
-						if !isRoot {
-							break
-						}
-					}
-					if isRoot {
-						rootTask = t
+		respondWithJSON(w, http.StatusOK, map[string]string{"status": "processed"})
+	}
+}
+
+// CompleteTask сохраняет результат задачи, выданной агенту agentID, завершает выражение, если это была
+// последняя задача, и ставит в очередь зависимые задачи, у которых все зависимости уже посчитаны.
+// Возвращает storage.ErrTaskNotAssigned, если задача у этого агента не в работе.
+func CompleteTask(ctx context.Context, s *storage.PostgresStorage, task *models.Task, agentID string, result float64) error {
+	if err := s.UpdateTaskResult(ctx, task.ID, agentID, result); err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+
+	tasks, err := s.GetTasksByExpressionID(ctx, task.ExpressionID)
+	if err != nil {
+		return fmt.Errorf("failed to get tasks for expression %d: %w", task.ExpressionID, err)
+	}
+
+	allCompleted := true
+	var finalResult float64
+	taskMap := make(map[string]*models.Task)
+	for _, t := range tasks {
+		taskMap[t.ID] = t
+		if t.Status != "completed" {
+			allCompleted = false
+		}
+	}
+
+	if allCompleted {
+		var rootTask *models.Task
+		for _, t := range tasks {
+			isRoot := true
+			for _, other := range tasks {
+				for _, dep := range other.DependsOn {
+					if dep == t.ID {
+						isRoot = false
 						break
 					}
 				}
-
-				if rootTask != nil && rootTask.Result != nil {
-					finalResult = *rootTask.Result
-					if err := s.UpdateExpressionResult(r.Context(), task.ExpressionID, finalResult); err != nil {
-						log.Printf("Failed to update expression %d: %v", task.ExpressionID, err)
-						respondWithError(w, http.StatusInternalServerError, "Failed to update expression")
-						return
-					}
-					log.Printf("Expression %d completed with result: %.2f", task.ExpressionID, finalResult)
+				if !isRoot {
+					break
 				}
 			}
-
-			dependentTasks, err := s.GetDependentTasks(r.Context(), req.ID)
-			if err != nil {
-				log.Printf("Failed to get dependent tasks for %s: %v", req.ID, err)
-			} else {
-				log.Printf("Found %d dependent tasks for task %s", len(dependentTasks), req.ID)
-				for _, depTask := range dependentTasks {
-					completed, err := s.CheckDependenciesCompleted(r.Context(), depTask.ID)
-					if err != nil {
-						log.Printf("Error checking dependencies for task %s: %v", depTask.ID, err)
-						continue
-					}
-					if completed {
-						if err := s.AddTaskToQueue(r.Context(), depTask.ID); err != nil {
-							log.Printf("Failed to add dependent task %s to queue: %v", depTask.ID, err)
-						} else {
-							log.Printf("Added dependent task %s to queue", depTask.ID)
-						}
-					} else {
-						log.Printf("Dependencies for task %s not yet completed", depTask.ID)
-					}
-				}
+			if isRoot {
+				rootTask = t
+				break
 			}
-		} else if req.Status == "pending" {
-			if err := s.AddTaskToQueue(r.Context(), req.ID); err != nil {
-				log.Printf("Failed to requeue task %s: %v", req.ID, err)
-				respondWithError(w, http.StatusInternalServerError, "Failed to requeue")
-				return
+		}
+
+		if rootTask != nil && rootTask.Result != nil {
+			finalResult = *rootTask.Result
+			if err := s.UpdateExpressionResult(ctx, task.ExpressionID, finalResult); err != nil {
+				return fmt.Errorf("failed to update expression %d: %w", task.ExpressionID, err)
 			}
-			log.Printf("Task %s requeued successfully", req.ID)
+			log.Printf("Expression %d completed with result: %.2f", task.ExpressionID, finalResult)
 		}
+	}
 
-		respondWithJSON(w, http.StatusOK, map[string]string{"status": "processed"})
+	dependentTasks, err := s.GetDependentTasks(ctx, task.ID)
+	if err != nil {
+		log.Printf("Failed to get dependent tasks for %s: %v", task.ID, err)
+		return nil
 	}
+
+	log.Printf("Found %d dependent tasks for task %s", len(dependentTasks), task.ID)
+	for _, depTask := range dependentTasks {
+		completed, err := s.CheckDependenciesCompleted(ctx, depTask.ID)
+		if err != nil {
+			log.Printf("Error checking dependencies for task %s: %v", depTask.ID, err)
+			continue
+		}
+		if completed {
+			if err := s.AddTaskToQueue(ctx, depTask.ID); err != nil {
+				log.Printf("Failed to add dependent task %s to queue: %v", depTask.ID, err)
+			} else {
+				log.Printf("Added dependent task %s to queue", depTask.ID)
+			}
+		} else {
+			log.Printf("Dependencies for task %s not yet completed", depTask.ID)
+		}
+	}
+
+	return nil
 }
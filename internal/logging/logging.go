@@ -0,0 +1,66 @@
+// Package logging добавляет уровни к стандартному log: сообщения ниже
+// установленного уровня не выводятся.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q: want debug, info, warn or error", s)
+}
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+func Enabled(l Level) bool {
+	return int32(l) >= current.Load()
+}
+
+func Debugf(format string, args ...any) { output(LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { output(LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { output(LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { output(LevelError, format, args...) }
+
+func output(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	// 3 — чтобы с флагом log.Lshortfile печатался вызывающий код, а не этот файл
+	log.Output(3, fmt.Sprintf(format, args...))
+}
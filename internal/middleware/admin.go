@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+)
+
+// AdminMiddleware пускает пользователей с ролью admin, а также запросы с заголовком
+// X-Admin-Token, равным token: по нему назначают первого администратора и работают скрипты.
+// Пустой token отключает вход по заголовку, но не роль admin.
+func AdminMiddleware(token string, s *storage.PostgresStorage, next http.Handler) http.Handler {
+	byRole := AuthMiddleware(s, RoleMiddleware(next, auth.RoleAdmin))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := r.Header.Get("X-Admin-Token")
+		if provided == "" {
+			byRole.ServeHTTP(w, r)
+			return
+		}
+
+		if token == "" {
+			handlers.WriteError(w, http.StatusForbidden, "", "Admin token is disabled")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Invalid admin token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,26 +2,137 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// AuthMiddleware пускает только пользователей: с токеном ("Authorization: Bearer ...") или
+// личным API-ключом ("Authorization: ApiKey ..."). Токены агентов и отозванные токены
+// здесь не принимаются. Для запросов по токену в контексте лежат и его claims.
+func AuthMiddleware(s *storage.PostgresStorage, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, `{"error": "Authorization header required"}`, http.StatusUnauthorized)
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Authorization header required")
+			return
+		}
+
+		if key, ok := auth.APIKeyFromHeader(authHeader); ok {
+			serveWithAPIKey(s, key, next, w, r)
 			return
 		}
 
 		claims, err := auth.ParseToken(authHeader)
 		if err != nil {
-			http.Error(w, `{"error": "Invalid token"}`, http.StatusUnauthorized)
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Invalid token")
+			return
+		}
+		if claims.IsAgent() {
+			handlers.WriteError(w, http.StatusForbidden, "", "Agent tokens are not accepted here")
+			return
+		}
+		// токены без jti выданы до появления отзыва, отозвать их нельзя
+		if claims.ID == "" || claims.ExpiresAt == nil {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Invalid token")
+			return
+		}
+
+		revoked, err := s.TokenRevoked(r.Context(), claims.ID, claims.UserID, claims.SessionVersion, claims.ExpiresAt.Time)
+		if err != nil {
+			log.Printf("Failed to check token of user %d: %v", claims.UserID, err)
+			handlers.WriteError(w, http.StatusInternalServerError, "", "Internal server error")
+			return
+		}
+		if revoked {
+			handlers.WriteError(w, http.StatusUnauthorized, handlers.CodeTokenRevoked, "Token revoked")
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+		ctx = context.WithValue(ctx, "role", claims.UserRole())
+		ctx = context.WithValue(ctx, "claims", claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serveWithAPIKey пропускает запрос по личному API-ключу. Ключ с правами read
+// пускается только на чтение. Ключ всегда действует с ролью user, даже если его
+// выпустил администратор.
+func serveWithAPIKey(s *storage.PostgresStorage, key string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	apiKey, err := s.UseAPIKey(r.Context(), auth.HashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Invalid API key")
+			return
+		}
+		log.Printf("Failed to check API key: %v", err)
+		handlers.WriteError(w, http.StatusInternalServerError, "", "Internal server error")
+		return
+	}
+
+	if apiKey.Scope == auth.ScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		handlers.WriteError(w, http.StatusForbidden, "", "API key is read-only")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "user_id", apiKey.UserID)
+	ctx = context.WithValue(ctx, "role", auth.RoleUser)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AgentAuthMiddleware защищает /internal/*: пускает только агентов с токеном,
+// выданным по действующему (не отозванному) API-ключу.
+func AgentAuthMiddleware(s *storage.PostgresStorage, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Authorization header required")
+			return
+		}
+
+		claims, err := auth.ParseToken(authHeader)
+		if err != nil {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Invalid token")
+			return
+		}
+		if !claims.IsAgent() {
+			handlers.WriteError(w, http.StatusForbidden, "", "Agent credentials required")
+			return
+		}
+
+		active, err := s.AgentKeyActive(r.Context(), claims.AgentKeyID)
+		if err != nil {
+			log.Printf("Failed to check agent key %d: %v", claims.AgentKeyID, err)
+			handlers.WriteError(w, http.StatusInternalServerError, "", "Internal server error")
+			return
+		}
+		if !active {
+			handlers.WriteError(w, http.StatusUnauthorized, "", "Agent key revoked")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "agent_key_id", claims.AgentKeyID)
+		ctx = context.WithValue(ctx, "role", auth.RoleAgent)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RoleMiddleware пускает только вызывающих с одной из ролей roles. Ставится после
+// AuthMiddleware или AgentAuthMiddleware, которые кладут роль в контекст.
+func RoleMiddleware(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value("role").(string)
+		if !slices.Contains(roles, role) {
+			handlers.WriteError(w, http.StatusForbidden, "", "Insufficient role")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
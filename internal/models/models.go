@@ -7,24 +7,31 @@ import (
 )
 
 type User struct {
-	ID           int       `json:"id"`
-	Login        string    `json:"login"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int    `json:"id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"-"`
+	Role         string `json:"role"`
+	// версия сессий: растёт при отзыве всех сессий пользователя
+	SessionVersion int       `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type Expression struct {
-	ID         int       `json:"id"`
-	UserID     int       `json:"user_id"`
-	Expression string    `json:"expression"`
-	Result     float64   `json:"result"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int     `json:"id"`
+	UserID     int     `json:"user_id"`
+	Expression string  `json:"expression"`
+	Result     float64 `json:"result"`
+	Status     string  `json:"status"`
+	Priority   int     `json:"priority"`
+	// OrgID — организация, которой принадлежит выражение; nil — личное выражение автора
+	OrgID     *int      `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Task struct {
@@ -37,6 +44,7 @@ type Task struct {
 	Status        string   `json:"status"`
 	Result        *float64 `json:"result"`
 	DependsOn     []string `json:"depends_on"`
+	Priority      int      `json:"priority"`
 }
 
 type RegisterRequest struct {
@@ -50,9 +58,207 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	// Token — токен доступа; ExpiresIn — через сколько секунд он истечёт
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutRequest — тело /api/v1/logout; без refresh-токена отзывается только токен доступа
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ChangePasswordRequest — тело PUT /api/v1/me/password
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// DeleteAccountRequest — тело DELETE /api/v1/me; удаление подтверждается паролем
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// UserUsage — статистика пользователя: число выражений по статусам и действующих API-ключей
+type UserUsage struct {
+	TotalExpressions int            `json:"total_expressions"`
+	Expressions      map[string]int `json:"expressions"`
+	ActiveAPIKeys    int            `json:"active_api_keys"`
+}
+
+// Profile — ответ GET /api/v1/me
+type Profile struct {
+	User
+	Usage UserUsage `json:"usage"`
+}
+
+// Приоритет выражения: от MinPriority до MaxPriority, больше — раньше
+const (
+	MinPriority     = 0
+	MaxPriority     = 9
+	DefaultPriority = 5
+)
+
 type ExpressionRequest struct {
 	Expression string `json:"expression"`
+	Priority   *int   `json:"priority"`
+	OrgID      *int   `json:"org_id"`
+}
+
+// StreamMessage — сообщение в канале /internal/agent/stream.
+// Агент отправляет hello, result и heartbeat, оркестратор — task.
+type StreamMessage struct {
+	Type        string   `json:"type"`
+	Concurrency int      `json:"concurrency,omitempty"`
+	Task        *Task    `json:"task,omitempty"`
+	TaskID      string   `json:"task_id,omitempty"`
+	Result      *float64 `json:"result,omitempty"`
+	Status      string   `json:"status,omitempty"`
+}
+
+type UserScheduling struct {
+	UserID      int     `json:"user_id"`
+	Login       string  `json:"login"`
+	Weight      int     `json:"weight"`
+	VirtualTime float64 `json:"virtual_time"`
+	Dispatched  int64   `json:"dispatched"`
+	Queued      int     `json:"queued"`
+}
+
+// SubmissionLimits — ограничения на отправку выражений пользователем, 0 — без ограничения
+type SubmissionLimits struct {
+	SubmissionsPerMinute  int `json:"submissions_per_minute"`
+	MaxPending            int `json:"max_pending"`
+	MaxTasksPerExpression int `json:"max_tasks_per_expression"`
+	MaxExpressionLength   int `json:"max_expression_length"`
+}
+
+// LoginProtection — защита входа от перебора паролей. Первые FreeAttempts неудачных попыток
+// подряд (для IP — IPFreeAttempts) проходят без задержки, дальше каждая следующая попытка
+// возможна только через BaseDelay, 2*BaseDelay, 4*BaseDelay... но не дольше MaxDelay.
+// Счётчик сбрасывается после успешного входа или через ResetAfter без ошибок.
+type LoginProtection struct {
+	FreeAttempts   int
+	IPFreeAttempts int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	ResetAfter     time.Duration
+}
+
+// Delay возвращает, на сколько блокируются попытки входа после failures неудачных подряд
+func (p LoginProtection) Delay(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := free + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LoginLockout — логин или IP, попытки входа с которого сейчас заблокированы
+type LoginLockout struct {
+	Scope       string    `json:"scope"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// FailedLogin — запись журнала неудачных входов
+type FailedLogin struct {
+	ID        int64     `json:"id"`
+	Login     string    `json:"login"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LimitOverrides — индивидуальные лимиты пользователя, nil — использовать значение по умолчанию
+type LimitOverrides struct {
+	SubmissionsPerMinute  *int `json:"submissions_per_minute"`
+	MaxPending            *int `json:"max_pending"`
+	MaxTasksPerExpression *int `json:"max_tasks_per_expression"`
+	MaxExpressionLength   *int `json:"max_expression_length"`
+}
+
+// Agent — зарегистрированный агент. Operations — операции, которые агент умеет вычислять.
+type Agent struct {
+	ID             string    `json:"id"`
+	Hostname       string    `json:"hostname"`
+	Version        string    `json:"version"`
+	Operations     []string  `json:"operations"`
+	Concurrency    int       `json:"concurrency"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	TasksCompleted int64     `json:"tasks_completed"`
+	Online         bool      `json:"online"`
+}
+
+// APIKey — личный API-ключ пользователя. Scope — auth.ScopeRead или auth.ScopeSubmit.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// AgentKey — API-ключ агента. Сам ключ показывается только при выдаче, в БД хранится его хэш.
+type AgentKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Роли участников организации по возрастанию прав: viewer видит выражения организации,
+// member ещё и отправляет их, admin управляет участниками, owner ещё и удаляет организацию
+// и назначает владельцев
+const (
+	OrgRoleViewer = "viewer"
+	OrgRoleMember = "member"
+	OrgRoleAdmin  = "admin"
+	OrgRoleOwner  = "owner"
+)
+
+var orgRoleRank = map[string]int{OrgRoleViewer: 1, OrgRoleMember: 2, OrgRoleAdmin: 3, OrgRoleOwner: 4}
+
+// IsOrgRole сообщает, является ли role ролью участника организации
+func IsOrgRole(role string) bool {
+	_, ok := orgRoleRank[role]
+	return ok
+}
+
+// OrgRoleAtLeast сообщает, есть ли у роли role все права роли required
+func OrgRoleAtLeast(role, required string) bool {
+	return IsOrgRole(role) && orgRoleRank[role] >= orgRoleRank[required]
+}
+
+// Organization — организация. Role — роль текущего пользователя в ней.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgMember — участник организации
+type OrgMember struct {
+	UserID   int       `json:"user_id"`
+	Login    string    `json:"login"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
 }
@@ -2,54 +2,183 @@ package orchestrator
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/config"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/grpcserver"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/middleware"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"google.golang.org/grpc"
 )
 
-func StartServer(connStr string) *http.Server {
+// Server — запущенный оркестратор: HTTP и gRPC серверы и всё, что нужно остановить при выходе.
+type Server struct {
+	HTTP *http.Server
 
-	store, err := storage.NewPostgresStorage(connStr)
+	grpc            *grpc.Server
+	store           *storage.PostgresStorage
+	stopListening   context.CancelFunc
+	stopMaintenance context.CancelFunc
+	shutdownTimeout time.Duration
+}
+
+// Как часто удаляются устаревшие записи о неудачных входах
+const maintenanceInterval = time.Hour
+
+// LoadSigningKeys применяет ключи подписи токенов из конфигурации. Вызывается при старте
+// и по SIGHUP, чтобы подхватить ключи, добавленные или удалённые командой keys.
+func LoadSigningKeys(cfg *config.Orchestrator) error {
+	ks, err := auth.LoadKeySet(cfg.JWTKeysFile, cfg.JWTSecret)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	auth.SetKeySet(ks)
+	log.Printf("Signing keys loaded, new tokens are signed with key %q", ks.SigningKeyID())
+	return nil
+}
+
+func StartServer(cfg *config.Orchestrator) *Server {
+	if err := LoadSigningKeys(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := storage.NewPostgresStorage(cfg.Database.DSN)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	store.DB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	store.DB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	store.DB.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime))
 
 	// маршруты
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/register", handlers.RegisterHandler(store))
-	mux.HandleFunc("/api/v1/login", handlers.LoginHandler(store))
-	mux.Handle("/api/v1/calculate", middleware.AuthMiddleware(http.HandlerFunc(handlers.ExpressionHandler(store))))
-	mux.Handle("/api/v1/expressions", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetExpressionsHandler(store))))
-	mux.Handle("/api/v1/expressions/", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetExpressionByIDHandler(store))))
-	mux.Handle("/internal/task", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetTaskHandler(store))))
-	mux.Handle("/internal/task/", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetTaskByIDHandler(store))))
-	mux.Handle("/internal/task/requeue", middleware.AuthMiddleware(http.HandlerFunc(handlers.RequeueTaskHandler(store))))
+	passwordPolicy, err := cfg.Password.Policy()
+	if err != nil {
+		log.Fatalf("Failed to load password policy: %v", err)
+	}
+	mux.HandleFunc("/api/v1/register", handlers.RegisterHandler(store, passwordPolicy))
+	mux.HandleFunc("/api/v1/login", handlers.LoginHandler(store, cfg.Login.Protection()))
+	mux.HandleFunc("/api/v1/token/refresh", handlers.RefreshHandler(store))
+	mux.HandleFunc("/.well-known/jwks.json", handlers.JWKSHandler())
+	handlers.SetDefaultOperationTimes(cfg.OperationTimes.ByOperator())
+	limits := cfg.Limits.SubmissionLimits()
+	mux.Handle("/api/v1/calculate", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.ExpressionHandler(store, limits))))
+	mux.Handle("/api/v1/expressions", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.GetExpressionsHandler(store))))
+	mux.Handle("/api/v1/expressions/", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.GetExpressionByIDHandler(store))))
+	mux.Handle("/api/v1/keys", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.APIKeysHandler(store))))
+	mux.Handle("/api/v1/keys/", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.RevokeAPIKeyHandler(store))))
+	mux.Handle("/api/v1/orgs", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.OrganizationsHandler(store))))
+	mux.Handle("/api/v1/orgs/", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.OrganizationHandler(store))))
+	mux.Handle("/api/v1/me", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.MeHandler(store, cfg.Login.Protection()))))
+	mux.Handle("/api/v1/me/password", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.ChangePasswordHandler(store, passwordPolicy, cfg.Login.Protection()))))
+	mux.Handle("/api/v1/logout", middleware.AuthMiddleware(store, http.HandlerFunc(handlers.LogoutHandler(store))))
+	mux.HandleFunc("/internal/agent/token", handlers.AgentTokenHandler(store))
+	mux.Handle("/internal/task", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.GetTaskHandler(store))))
+	mux.Handle("/internal/task/", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.GetTaskByIDHandler(store))))
+	mux.Handle("/internal/task/requeue", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.RequeueTaskHandler(store))))
+	mux.Handle("/internal/agents", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.AgentsHandler(store))))
+	mux.Handle("/internal/agents/", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.AgentHeartbeatHandler(store))))
+	if cfg.Features.AgentStream {
+		mux.Handle("/internal/agent/stream", middleware.AgentAuthMiddleware(store, http.HandlerFunc(handlers.AgentStreamHandler(store))))
+	}
+
+	// администрирование
+	adminToken := cfg.AdminToken
+	mux.Handle("/api/v1/admin/scheduling", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.GetSchedulingHandler(store))))
+	mux.Handle("/api/v1/admin/scheduling/", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.SetUserWeightHandler(store))))
+	mux.Handle("/api/v1/admin/operation-times", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.OperationTimesHandler(store))))
+	mux.Handle("/api/v1/admin/limits/", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.UserLimitsHandler(store, limits))))
+	mux.Handle("/api/v1/admin/agent-keys", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.AgentKeysHandler(store))))
+	mux.Handle("/api/v1/admin/users", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.AdminUsersHandler(store))))
+	mux.Handle("/api/v1/admin/users/", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.AdminUserHandler(store))))
+	mux.Handle("/api/v1/admin/expressions/", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.AdminExpressionHandler(store))))
+	mux.Handle("/api/v1/admin/queue", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.PurgeQueueHandler(store))))
+	mux.Handle("/api/v1/admin/login-lockouts", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.LoginLockoutsHandler(store))))
+	mux.Handle("/api/v1/admin/failed-logins", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.FailedLoginsHandler(store))))
+	mux.Handle("/api/v1/admin/agents", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.AdminAgentsHandler(store))))
+	mux.Handle("/api/v1/admin/agent-keys/", middleware.AdminMiddleware(adminToken, store, http.HandlerFunc(handlers.RevokeAgentKeyHandler(store))))
 
 	// статика
 	fs := http.FileServer(http.Dir("styles"))
 	mux.Handle("/styles/", http.StripPrefix("/styles/", fs))
 
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(cfg.HTTP.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.HTTP.IdleTimeout),
 	}
 
 	go func() {
-		log.Println("Server running on :8080")
+		log.Printf("Server running on %s", cfg.ListenAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
+	var grpcServer *grpc.Server
+	if cfg.Features.GRPC {
+		grpcListener, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", cfg.GRPCAddr, err)
+		}
+		grpcServer = grpcserver.NewServer(store)
+
+		go func() {
+			log.Printf("gRPC server running on %s", cfg.GRPCAddr)
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Printf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	if cfg.Features.QueueNotify {
+		if err := store.ListenTaskQueue(listenCtx); err != nil {
+			log.Printf("Task queue listener disabled, agents on other replicas will wait for poll timeout: %v", err)
+		}
+	}
+
 	if err := initTaskQueue(store); err != nil {
 		log.Fatalf("Failed to init task queue: %v", err)
 	}
 
-	return server
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	go runMaintenance(maintenanceCtx, store, cfg.Login.Protection())
+
+	return &Server{
+		HTTP:            server,
+		grpc:            grpcServer,
+		store:           store,
+		stopListening:   stopListening,
+		stopMaintenance: stopMaintenance,
+		shutdownTimeout: time.Duration(cfg.ShutdownTimeout),
+	}
+}
+
+// runMaintenance раз в maintenanceInterval чистит историю входов, пока не отменён ctx
+func runMaintenance(ctx context.Context, store *storage.PostgresStorage, protection models.LoginProtection) {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		if err := store.PruneLoginHistory(ctx, protection); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to prune login history: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func initTaskQueue(store *storage.PostgresStorage) error {
@@ -76,10 +205,44 @@ func initTaskQueue(store *storage.PostgresStorage) error {
 	return nil
 }
 
-func ShutdownServer(server *http.Server) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// ShutdownServer останавливает оркестратор: агенты, ждущие задачу, получают ответ
+// «повторите на другой реплике», начатые запросы дорабатывают, стримы агентов
+// возвращают невыполненные задачи в очередь, после чего закрывается пул соединений с БД.
+func ShutdownServer(server *Server) {
+	log.Println("Shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
+
+	// сначала перестаём выдавать задачи, иначе long-poll держали бы Shutdown до таймаута
+	server.store.StopDispatch()
+
+	if err := server.HTTP.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown failed: %v", err)
 	}
+
+	if server.grpc != nil {
+		grpcStopped := make(chan struct{})
+		go func() {
+			server.grpc.GracefulStop()
+			close(grpcStopped)
+		}()
+		select {
+		case <-grpcStopped:
+		case <-ctx.Done():
+			log.Println("gRPC server did not stop in time, closing connections")
+			server.grpc.Stop()
+			<-grpcStopped
+		}
+	}
+
+	if err := handlers.WaitAgentStreams(ctx); err != nil {
+		log.Printf("Agent streams did not close in time: %v", err)
+	}
+
+	server.stopListening()
+	server.stopMaintenance()
+	if err := server.store.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+const agentKeyColumns = `id, name, prefix, key_hash, created_at, last_used_at, revoked_at`
+
+func (s *PostgresStorage) CreateAgentKey(ctx context.Context, key *models.AgentKey) error {
+	row := s.DB.QueryRowContext(ctx, `
+        INSERT INTO agent_keys (name, prefix, key_hash)
+        VALUES ($1, $2, $3)
+        RETURNING `+agentKeyColumns,
+		key.Name, key.Prefix, key.KeyHash)
+	if err := scanAgentKey(row, key); err != nil {
+		return fmt.Errorf("failed to create agent key: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ListAgentKeys(ctx context.Context) ([]models.AgentKey, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+agentKeyColumns+` FROM agent_keys ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.AgentKey
+	for rows.Next() {
+		var key models.AgentKey
+		if err := scanAgentKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("failed to scan agent key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UseAgentKey находит действующий ключ по хэшу и отмечает время его использования.
+// Возвращает sql.ErrNoRows, если ключа нет или он отозван.
+func (s *PostgresStorage) UseAgentKey(ctx context.Context, keyHash string) (*models.AgentKey, error) {
+	var key models.AgentKey
+	row := s.DB.QueryRowContext(ctx, `
+        UPDATE agent_keys SET last_used_at = CURRENT_TIMESTAMP
+        WHERE key_hash = $1 AND revoked_at IS NULL
+        RETURNING `+agentKeyColumns,
+		keyHash)
+	if err := scanAgentKey(row, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// AgentKeyActive сообщает, не отозван ли ключ, по которому выдан токен агента
+func (s *PostgresStorage) AgentKeyActive(ctx context.Context, id int) (bool, error) {
+	var active bool
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM agent_keys WHERE id = $1 AND revoked_at IS NULL)",
+		id).Scan(&active)
+	return active, err
+}
+
+// RevokeAgentKey отзывает ключ. Возвращает sql.ErrNoRows, если ключа нет.
+func (s *PostgresStorage) RevokeAgentKey(ctx context.Context, id int) (*models.AgentKey, error) {
+	var key models.AgentKey
+	row := s.DB.QueryRowContext(ctx, `
+        UPDATE agent_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+        WHERE id = $1
+        RETURNING `+agentKeyColumns,
+		id)
+	if err := scanAgentKey(row, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func scanAgentKey(row rowScanner, key *models.AgentKey) error {
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &key.CreatedAt, &lastUsed, &revoked); err != nil {
+		return err
+	}
+	key.LastUsedAt = nullTimePtr(lastUsed)
+	key.RevokedAt = nullTimePtr(revoked)
+	return nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/lib/pq"
+)
+
+// Агент считается отключённым, если от него столько времени не было heartbeat
+const AgentOfflineAfter = 45 * time.Second
+
+const agentColumns = `id, hostname, version, operations, concurrency, registered_at, last_seen, tasks_completed,
+               last_seen > CURRENT_TIMESTAMP - make_interval(secs => $1)`
+
+// ErrAgentIDTaken возвращается, если идентификатор занят агентом с другим действующим ключом
+var ErrAgentIDTaken = errors.New("agent ID is registered with another key")
+
+// RegisterAgent регистрирует агента или обновляет данные уже зарегистрированного.
+// Агент привязывается к ключу keyID; занять его идентификатор другим ключом можно,
+// только если прежний ключ отозван.
+func (s *PostgresStorage) RegisterAgent(ctx context.Context, agent *models.Agent, keyID int) error {
+	row := s.DB.QueryRowContext(ctx, `
+        INSERT INTO agents (id, hostname, version, operations, concurrency, agent_key_id)
+        VALUES ($2, $3, $4, $5, $6, $7)
+        ON CONFLICT (id) DO UPDATE
+        SET hostname = EXCLUDED.hostname,
+            version = EXCLUDED.version,
+            operations = EXCLUDED.operations,
+            concurrency = EXCLUDED.concurrency,
+            agent_key_id = EXCLUDED.agent_key_id,
+            last_seen = CURRENT_TIMESTAMP
+        WHERE agents.agent_key_id = EXCLUDED.agent_key_id
+           OR NOT EXISTS (SELECT 1 FROM agent_keys k WHERE k.id = agents.agent_key_id AND k.revoked_at IS NULL)
+        RETURNING `+agentColumns,
+		AgentOfflineAfter.Seconds(), agent.ID, agent.Hostname, agent.Version, pq.StringArray(agent.Operations), agent.Concurrency, keyID)
+	if err := scanAgent(row, agent); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAgentIDTaken
+		}
+		return fmt.Errorf("failed to register agent %s: %w", agent.ID, err)
+	}
+	return nil
+}
+
+// TouchAgent отмечает, что агент жив, и возвращает его данные.
+// Возвращает sql.ErrNoRows, если агент не зарегистрирован с ключом keyID.
+func (s *PostgresStorage) TouchAgent(ctx context.Context, id string, keyID int) (*models.Agent, error) {
+	var agent models.Agent
+	row := s.DB.QueryRowContext(ctx, `
+        UPDATE agents SET last_seen = CURRENT_TIMESTAMP WHERE id = $2 AND agent_key_id = $3
+        RETURNING `+agentColumns,
+		AgentOfflineAfter.Seconds(), id, keyID)
+	if err := scanAgent(row, &agent); err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+func (s *PostgresStorage) ListAgents(ctx context.Context) ([]models.Agent, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+agentColumns+` FROM agents ORDER BY last_seen DESC, id`,
+		AgentOfflineAfter.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agents: %w", err)
+	}
+	defer rows.Close()
+
+	var agents []models.Agent
+	for rows.Next() {
+		var agent models.Agent
+		if err := scanAgent(rows, &agent); err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return agents, nil
+}
+
+func (s *PostgresStorage) IncrementAgentTasksCompleted(ctx context.Context, id string, keyID int) error {
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE agents SET tasks_completed = tasks_completed + 1, last_seen = CURRENT_TIMESTAMP WHERE id = $1 AND agent_key_id = $2",
+		id, keyID)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAgent(row rowScanner, agent *models.Agent) error {
+	var operations pq.StringArray
+	err := row.Scan(&agent.ID, &agent.Hostname, &agent.Version, &operations, &agent.Concurrency,
+		&agent.RegisteredAt, &agent.LastSeen, &agent.TasksCompleted, &agent.Online)
+	agent.Operations = []string(operations)
+	return err
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scope, created_at, last_used_at, revoked_at`
+
+func (s *PostgresStorage) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	row := s.DB.QueryRowContext(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scope)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+apiKeyColumns,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scope)
+	if err := scanAPIKey(row, key); err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// ListAPIKeys возвращает ключи пользователя, включая отозванные
+func (s *PostgresStorage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UseAPIKey находит действующий ключ по хэшу и отмечает время его использования.
+// Возвращает sql.ErrNoRows, если ключа нет или он отозван.
+func (s *PostgresStorage) UseAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	row := s.DB.QueryRowContext(ctx, `
+        UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP
+        WHERE key_hash = $1 AND revoked_at IS NULL
+        RETURNING `+apiKeyColumns,
+		keyHash)
+	if err := scanAPIKey(row, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeAPIKey отзывает ключ пользователя userID. Возвращает sql.ErrNoRows,
+// если ключа нет или он принадлежит другому пользователю.
+func (s *PostgresStorage) RevokeAPIKey(ctx context.Context, userID, id int) (*models.APIKey, error) {
+	var key models.APIKey
+	row := s.DB.QueryRowContext(ctx, `
+        UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+        WHERE id = $1 AND user_id = $2
+        RETURNING `+apiKeyColumns,
+		id, userID)
+	if err := scanAPIKey(row, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func scanAPIKey(row rowScanner, key *models.APIKey) error {
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &key.Scope,
+		&key.CreatedAt, &lastUsed, &revoked); err != nil {
+		return err
+	}
+	key.LastUsedAt = nullTimePtr(lastUsed)
+	key.RevokedAt = nullTimePtr(revoked)
+	return nil
+}
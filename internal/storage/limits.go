@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+func (s *PostgresStorage) GetLimitOverrides(ctx context.Context, userID int) (*models.LimitOverrides, error) {
+	var o models.LimitOverrides
+	var perMinute, maxPending, maxTasks, maxLength sql.NullInt64
+
+	err := s.DB.QueryRowContext(ctx, `
+        SELECT submissions_per_minute, max_pending, max_tasks_per_expression, max_expression_length
+        FROM user_limits WHERE user_id = $1`,
+		userID).Scan(&perMinute, &maxPending, &maxTasks, &maxLength)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &o, nil
+		}
+		return nil, fmt.Errorf("failed to get limits for user %d: %w", userID, err)
+	}
+
+	o.SubmissionsPerMinute = nullIntPtr(perMinute)
+	o.MaxPending = nullIntPtr(maxPending)
+	o.MaxTasksPerExpression = nullIntPtr(maxTasks)
+	o.MaxExpressionLength = nullIntPtr(maxLength)
+	return &o, nil
+}
+
+// SetLimitOverrides сохраняет индивидуальные лимиты пользователя.
+// Возвращает sql.ErrNoRows, если пользователя нет.
+func (s *PostgresStorage) SetLimitOverrides(ctx context.Context, userID int, o *models.LimitOverrides) error {
+	var id int
+	return s.DB.QueryRowContext(ctx, `
+        INSERT INTO user_limits (user_id, submissions_per_minute, max_pending, max_tasks_per_expression, max_expression_length)
+        SELECT id, $2, $3, $4, $5 FROM users WHERE id = $1
+        ON CONFLICT (user_id) DO UPDATE
+        SET submissions_per_minute = EXCLUDED.submissions_per_minute,
+            max_pending = EXCLUDED.max_pending,
+            max_tasks_per_expression = EXCLUDED.max_tasks_per_expression,
+            max_expression_length = EXCLUDED.max_expression_length
+        RETURNING user_id`,
+		userID, o.SubmissionsPerMinute, o.MaxPending, o.MaxTasksPerExpression, o.MaxExpressionLength).Scan(&id)
+}
+
+// TakeSubmissionSlot учитывает отправку выражения в текущем минутном окне, если в нём
+// меньше limit отправок. Иначе счётчик не меняется, а возвращается false и время до конца окна
+// по часам базы данных, чтобы все реплики считали одинаково.
+func (s *PostgresStorage) TakeSubmissionSlot(ctx context.Context, userID, limit int) (bool, time.Duration, error) {
+	// прошлые окна больше не нужны
+	_, err := s.DB.ExecContext(ctx,
+		"DELETE FROM submission_counters WHERE user_id = $1 AND window_start < date_trunc('minute', CURRENT_TIMESTAMP)",
+		userID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to clean up submission counters: %w", err)
+	}
+
+	// проверка и увеличение одним запросом, чтобы параллельные отправки не превысили лимит
+	var count int
+	err = s.DB.QueryRowContext(ctx, `
+        INSERT INTO submission_counters (user_id, window_start, count)
+        VALUES ($1, date_trunc('minute', CURRENT_TIMESTAMP), 1)
+        ON CONFLICT (user_id, window_start) DO UPDATE SET count = submission_counters.count + 1
+        WHERE submission_counters.count < $2
+        RETURNING count`,
+		userID, limit).Scan(&count)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, fmt.Errorf("failed to increment submission counter: %w", err)
+	}
+
+	var remaining float64
+	err = s.DB.QueryRowContext(ctx,
+		"SELECT EXTRACT(EPOCH FROM (date_trunc('minute', CURRENT_TIMESTAMP) + INTERVAL '1 minute' - CURRENT_TIMESTAMP))").
+		Scan(&remaining)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to get submission window: %w", err)
+	}
+	return false, time.Duration(remaining * float64(time.Second)), nil
+}
+
+// ReleaseSubmissionSlot возвращает отправку, учтённую TakeSubmissionSlot, если выражение
+// в итоге не было принято (например, не разобралось)
+func (s *PostgresStorage) ReleaseSubmissionSlot(ctx context.Context, userID int) error {
+	_, err := s.DB.ExecContext(ctx, `
+        UPDATE submission_counters SET count = count - 1
+        WHERE user_id = $1 AND window_start = date_trunc('minute', CURRENT_TIMESTAMP) AND count > 0`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to release submission slot: %w", err)
+	}
+	return nil
+}
+
+// CreateExpressionWithinPending создаёт выражение, если у пользователя меньше maxPending выражений
+// в работе (0 — без ограничения). Иначе выражение не создаётся и возвращается false.
+// Проверка и вставка идут в одной транзакции под блокировкой строки пользователя,
+// поэтому параллельные отправки одного пользователя не превысят лимит.
+func (s *PostgresStorage) CreateExpressionWithinPending(ctx context.Context, expr *models.Expression, maxPending int) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if maxPending > 0 {
+		// FOR NO KEY UPDATE не мешает проверкам внешних ключей, но сериализует отправки пользователя
+		var pending int
+		err = tx.QueryRowContext(ctx, `
+            SELECT (SELECT COUNT(*) FROM expressions WHERE user_id = u.id AND status = 'pending')
+            FROM users u WHERE u.id = $1 FOR NO KEY UPDATE`,
+			expr.UserID).Scan(&pending)
+		if err != nil {
+			return false, fmt.Errorf("failed to count pending expressions of user %d: %w", expr.UserID, err)
+		}
+		if pending >= maxPending {
+			return false, nil
+		}
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO expressions (user_id, expression, status, priority, org_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		expr.UserID, expr.Expression, expr.Status, expr.Priority, expr.OrgID).Scan(&expr.ID, &expr.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create expression: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+// Счётчики неудачных попыток входа ведутся отдельно по логину и по IP
+const (
+	LoginScope = "login"
+	IPScope    = "ip"
+)
+
+// Сколько хранится журнал неудачных входов
+const failedLoginRetention = 30 * 24 * time.Hour
+
+// BeginLoginAttempt заранее засчитывает попытку входа с логином login с адреса ip как неудачную,
+// чтобы параллельные попытки не проскочили блокировку, пока проверяется пароль. При успешном
+// входе попытку нужно отменить через FinishLoginAttempt. Если логин или IP заблокированы,
+// попытка не засчитывается и возвращается, через сколько её можно повторить.
+func (s *PostgresStorage) BeginLoginAttempt(ctx context.Context, login, ip string, p models.LoginProtection) (time.Duration, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type counter struct {
+		scope, key string
+		free       int
+		failures   int
+	}
+	counters := []*counter{
+		{scope: LoginScope, key: login, free: p.FreeAttempts},
+		{scope: IPScope, key: ip, free: p.IPFreeAttempts},
+	}
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, c := range counters {
+		// DO UPDATE, а не DO NOTHING: существующая строка сразу блокируется, и PruneLoginHistory
+		// не удалит её между вставкой и чтением
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO login_failures (scope, key, failures, last_failure_at) VALUES ($1, $2, 0, $3)
+            ON CONFLICT (scope, key) DO UPDATE SET failures = login_failures.failures`,
+			c.scope, c.key, now); err != nil {
+			return 0, fmt.Errorf("failed to create login failure counter: %w", err)
+		}
+
+		var (
+			lastFailure time.Time
+			lockedUntil sql.NullTime
+		)
+		if err := tx.QueryRowContext(ctx, `
+            SELECT failures, last_failure_at, locked_until FROM login_failures
+            WHERE scope = $1 AND key = $2
+            FOR UPDATE`,
+			c.scope, c.key).Scan(&c.failures, &lastFailure, &lockedUntil); err != nil {
+			return 0, fmt.Errorf("failed to get login failure counter: %w", err)
+		}
+
+		if now.Sub(lastFailure) >= p.ResetAfter {
+			c.failures = 0
+			continue
+		}
+		if lockedUntil.Valid && lockedUntil.Time.After(now) {
+			retryAfter = max(retryAfter, lockedUntil.Time.Sub(now))
+		}
+	}
+
+	if retryAfter == 0 {
+		for _, c := range counters {
+			c.failures++
+			var lockedUntil sql.NullTime
+			if delay := p.Delay(c.failures, c.free); delay > 0 {
+				lockedUntil = sql.NullTime{Time: now.Add(delay), Valid: true}
+			}
+			if _, err := tx.ExecContext(ctx, `
+                UPDATE login_failures SET failures = $3, last_failure_at = $4, locked_until = $5
+                WHERE scope = $1 AND key = $2`,
+				c.scope, c.key, c.failures, now, lockedUntil); err != nil {
+				return 0, fmt.Errorf("failed to update login failure counter: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return retryAfter, nil
+}
+
+// FinishLoginAttempt отменяет попытку, засчитанную BeginLoginAttempt, после успешного входа:
+// счётчик логина сбрасывается, счётчик IP уменьшается на эту попытку.
+func (s *PostgresStorage) FinishLoginAttempt(ctx context.Context, login, ip string, p models.LoginProtection) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM login_failures WHERE scope = $1 AND key = $2", LoginScope, login); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE login_failures
+        SET failures = GREATEST(failures - 1, 0),
+            locked_until = CASE WHEN failures - 1 > $3 THEN locked_until END
+        WHERE scope = $1 AND key = $2`,
+		IPScope, ip, p.IPFreeAttempts); err != nil {
+		return fmt.Errorf("failed to update IP login failures: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RecordFailedLogin добавляет запись в журнал неудачных входов
+func (s *PostgresStorage) RecordFailedLogin(ctx context.Context, login, ip, reason string) error {
+	if _, err := s.DB.ExecContext(ctx,
+		"INSERT INTO failed_logins (login, ip, reason) VALUES ($1, $2, $3)",
+		login, ip, reason); err != nil {
+		return fmt.Errorf("failed to record failed login: %w", err)
+	}
+	return nil
+}
+
+// PruneLoginHistory удаляет устаревшие записи журнала неудачных входов и счётчики попыток,
+// которые BeginLoginAttempt всё равно сбросил бы: без ошибок дольше p.ResetAfter.
+// Вызывается периодически, а не при каждом входе, чтобы не нагружать вход.
+func (s *PostgresStorage) PruneLoginHistory(ctx context.Context, p models.LoginProtection) error {
+	now := time.Now()
+	if _, err := s.DB.ExecContext(ctx,
+		"DELETE FROM failed_logins WHERE created_at < $1",
+		now.Add(-failedLoginRetention)); err != nil {
+		return fmt.Errorf("failed to delete old failed logins: %w", err)
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"DELETE FROM login_failures WHERE last_failure_at <= $1",
+		now.Add(-p.ResetAfter)); err != nil {
+		return fmt.Errorf("failed to delete stale login failures: %w", err)
+	}
+	return nil
+}
+
+// ListFailedLogins возвращает последние limit записей журнала неудачных входов
+func (s *PostgresStorage) ListFailedLogins(ctx context.Context, limit int) ([]models.FailedLogin, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT id, login, ip, reason, created_at FROM failed_logins
+        ORDER BY id DESC LIMIT $1`,
+		limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed logins: %w", err)
+	}
+	defer rows.Close()
+
+	var logins []models.FailedLogin
+	for rows.Next() {
+		var l models.FailedLogin
+		if err := rows.Scan(&l.ID, &l.Login, &l.IP, &l.Reason, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan failed login: %w", err)
+		}
+		logins = append(logins, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return logins, nil
+}
+
+// ListLoginLockouts возвращает логины и IP, вход с которых сейчас заблокирован
+func (s *PostgresStorage) ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT scope, key, failures, locked_until FROM login_failures
+        WHERE locked_until > $1
+        ORDER BY locked_until DESC`,
+		time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	var lockouts []models.LoginLockout
+	for rows.Next() {
+		var l models.LoginLockout
+		if err := rows.Scan(&l.Scope, &l.Key, &l.Failures, &l.LockedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan login lockout: %w", err)
+		}
+		lockouts = append(lockouts, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return lockouts, nil
+}
+
+// UnlockLogin сбрасывает счётчик неудачных попыток логина или IP (scope — LoginScope или IPScope).
+// Возвращает sql.ErrNoRows, если неудачных попыток не было.
+func (s *PostgresStorage) UnlockLogin(ctx context.Context, scope, key string) error {
+	res, err := s.DB.ExecContext(ctx,
+		"DELETE FROM login_failures WHERE scope = $1 AND key = $2", scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to unlock login: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "sync"
+
+// TaskNotifier будит всех, кто ждёт появления задач в task_queue.
+type TaskNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func NewTaskNotifier() *TaskNotifier {
+	return &TaskNotifier{ch: make(chan struct{})}
+}
+
+// Wait возвращает канал, который закроется при следующем вызове Notify.
+// Канал нужно получить до проверки очереди, иначе можно пропустить сигнал.
+func (n *TaskNotifier) Wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *TaskNotifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
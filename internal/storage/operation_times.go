@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+func (s *PostgresStorage) GetOperationTimeOverrides(ctx context.Context) (map[string]int, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT operation, duration_ms FROM operation_times")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operation times: %w", err)
+	}
+	defer rows.Close()
+
+	times := make(map[string]int)
+	for rows.Next() {
+		var op string
+		var ms int
+		if err := rows.Scan(&op, &ms); err != nil {
+			return nil, fmt.Errorf("failed to scan operation time: %w", err)
+		}
+		times[op] = ms
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return times, nil
+}
+
+func (s *PostgresStorage) SetOperationTimeOverrides(ctx context.Context, times map[string]int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for op, ms := range times {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO operation_times (operation, duration_ms) VALUES ($1, $2)
+            ON CONFLICT (operation) DO UPDATE
+            SET duration_ms = EXCLUDED.duration_ms, updated_at = CURRENT_TIMESTAMP`,
+			op, ms)
+		if err != nil {
+			return fmt.Errorf("failed to set time for operation %s: %w", op, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) ResetOperationTimeOverrides(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM operation_times")
+	return err
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrOrgNameTaken  = errors.New("organization name is already taken")
+	ErrAlreadyMember = errors.New("user is already a member of the organization")
+	// у организации с участниками должен оставаться хотя бы один владелец
+	ErrLastOwner = errors.New("organization must keep at least one owner")
+)
+
+// CreateOrganization создаёт организацию, её владельцем становится ownerID.
+// Возвращает ErrOrgNameTaken, если имя занято.
+func (s *PostgresStorage) CreateOrganization(ctx context.Context, org *models.Organization, ownerID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at",
+		org.Name).Scan(&org.ID, &org.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrOrgNameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)",
+		org.ID, ownerID, models.OrgRoleOwner); err != nil {
+		return fmt.Errorf("failed to add organization owner: %w", err)
+	}
+	org.Role = models.OrgRoleOwner
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListUserOrganizations возвращает организации пользователя вместе с его ролью в каждой
+func (s *PostgresStorage) ListUserOrganizations(ctx context.Context, userID int) ([]models.Organization, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT o.id, o.name, m.role, o.created_at
+        FROM organizations o JOIN organization_members m ON m.org_id = o.id
+        WHERE m.user_id = $1
+        ORDER BY o.id`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Role, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return orgs, nil
+}
+
+// GetOrganization возвращает организацию. Возвращает sql.ErrNoRows, если её нет.
+func (s *PostgresStorage) GetOrganization(ctx context.Context, orgID int) (*models.Organization, error) {
+	var org models.Organization
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT id, name, created_at FROM organizations WHERE id = $1",
+		orgID).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// DeleteOrganization удаляет организацию; её выражения возвращаются авторам, а выражения
+// удалённых пользователей удаляются. Возвращает sql.ErrNoRows, если организации нет.
+func (s *PostgresStorage) DeleteOrganization(ctx context.Context, orgID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := deleteOrganization(ctx, tx, orgID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteOrganization удаляет организацию вместе с выражениями без автора и их задачами:
+// вернуть такие выражения некому. Возвращает sql.ErrNoRows, если организации нет.
+func deleteOrganization(ctx context.Context, tx *sql.Tx, orgID int) error {
+	steps := []struct {
+		query, what string
+	}{
+		{`DELETE FROM task_queue WHERE task_id IN (
+              SELECT t.id FROM tasks t JOIN expressions e ON e.id = t.expression_id
+              WHERE e.org_id = $1 AND e.user_id IS NULL)`,
+			"queued organization tasks"},
+		{`DELETE FROM tasks WHERE expression_id IN (
+              SELECT id FROM expressions WHERE org_id = $1 AND user_id IS NULL)`,
+			"organization tasks"},
+		{"DELETE FROM expressions WHERE org_id = $1 AND user_id IS NULL", "organization expressions"},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, orgID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", step.what, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_scheduling WHERE user_id = $1", orgSchedulingID(orgID)); err != nil {
+		return fmt.Errorf("failed to delete organization scheduling state: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM organizations WHERE id = $1", orgID)
+	if err != nil {
+		return fmt.Errorf("failed to delete organization: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetMemberRole возвращает роль пользователя в организации.
+// Возвращает sql.ErrNoRows, если он в ней не состоит.
+func (s *PostgresStorage) GetMemberRole(ctx context.Context, orgID, userID int) (string, error) {
+	var role string
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT role FROM organization_members WHERE org_id = $1 AND user_id = $2",
+		orgID, userID).Scan(&role)
+	return role, err
+}
+
+// ListMembers возвращает участников организации
+func (s *PostgresStorage) ListMembers(ctx context.Context, orgID int) ([]models.OrgMember, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT m.user_id, u.login, m.role, m.joined_at
+        FROM organization_members m JOIN users u ON u.id = m.user_id
+        WHERE m.org_id = $1
+        ORDER BY m.joined_at, m.user_id`,
+		orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query organization members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.OrgMember
+	for rows.Next() {
+		var m models.OrgMember
+		if err := rows.Scan(&m.UserID, &m.Login, &m.Role, &m.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization member: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return members, nil
+}
+
+// AddMember добавляет пользователя с логином login в организацию с ролью role.
+// Возвращает sql.ErrNoRows, если такого пользователя нет, и ErrAlreadyMember, если он уже участник.
+func (s *PostgresStorage) AddMember(ctx context.Context, orgID int, login, role string) (*models.OrgMember, error) {
+	m := models.OrgMember{Login: login, Role: role}
+	err := s.DB.QueryRowContext(ctx, `
+        INSERT INTO organization_members (org_id, user_id, role)
+        SELECT $1, id, $3 FROM users WHERE login = $2
+        RETURNING user_id, joined_at`,
+		orgID, login, role).Scan(&m.UserID, &m.JoinedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrAlreadyMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SetMemberRole меняет роль участника. Возвращает sql.ErrNoRows, если он не состоит
+// в организации, и ErrLastOwner, если так организация осталась бы без владельца.
+func (s *PostgresStorage) SetMemberRole(ctx context.Context, orgID, userID int, role string) error {
+	return s.changeMember(ctx, orgID, userID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"UPDATE organization_members SET role = $3 WHERE org_id = $1 AND user_id = $2",
+			orgID, userID, role)
+		return err
+	})
+}
+
+// RemoveMember исключает участника из организации; отправленные им выражения остаются
+// в организации. Когда уходит последний участник, организация удаляется. Ошибки — как у SetMemberRole.
+func (s *PostgresStorage) RemoveMember(ctx context.Context, orgID, userID int) error {
+	return s.changeMember(ctx, orgID, userID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+		return err
+	})
+}
+
+// changeMember применяет change к участнику и проверяет, что у организации остался владелец.
+// Строка организации блокируется, чтобы два владельца не могли одновременно снять друг друга.
+func (s *PostgresStorage) changeMember(ctx context.Context, orgID, userID int, change func(*sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE", orgID); err != nil {
+		return fmt.Errorf("failed to lock organization: %w", err)
+	}
+
+	var role string
+	if err := tx.QueryRowContext(ctx,
+		"SELECT role FROM organization_members WHERE org_id = $1 AND user_id = $2",
+		orgID, userID).Scan(&role); err != nil {
+		return err
+	}
+
+	if err := change(tx); err != nil {
+		return fmt.Errorf("failed to change organization member: %w", err)
+	}
+
+	if role == models.OrgRoleOwner {
+		var members, owners int
+		if err := tx.QueryRowContext(ctx, `
+            SELECT COUNT(*), COUNT(*) FILTER (WHERE role = $2)
+            FROM organization_members WHERE org_id = $1`,
+			orgID, models.OrgRoleOwner).Scan(&members, &owners); err != nil {
+			return fmt.Errorf("failed to check organization owners: %w", err)
+		}
+		switch {
+		case members == 0:
+			// последний участник ушёл — организация больше никому не нужна
+			if err := deleteOrganization(ctx, tx, orgID); err != nil {
+				return err
+			}
+		case owners == 0:
+			return ErrLastOwner
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -6,14 +6,41 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 )
 
+// За каждые priorityAgingInterval ожидания в очереди задача получает +1 к приоритету,
+// чтобы низкоприоритетные задачи не голодали
+const priorityAgingInterval = 30 * time.Second
+
+// Канал NOTIFY, через который реплики оркестратора сообщают о новых задачах в очереди
+const taskQueueChannel = "task_queue"
+
+// ErrLoginTaken — пользователь с таким логином уже есть
+var ErrLoginTaken = errors.New("login is already taken")
+
+// ErrShuttingDown возвращается ожидающим задачу, когда оркестратор останавливается
+// и агенту нужно повторить запрос к другой реплике.
+var ErrShuttingDown = errors.New("orchestrator is shutting down")
+
+// ErrTaskNotAssigned — задача не выдана этому агенту или уже не в работе
+// (посчитана, возвращена в очередь или выдана другому агенту).
+var ErrTaskNotAssigned = errors.New("task is not assigned to this agent")
+
 type PostgresStorage struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Notifier *TaskNotifier
+	connStr  string
+
+	stopDispatch     chan struct{}
+	stopDispatchOnce sync.Once
+
+	revocations revocationCache
 }
 
 func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
@@ -26,21 +53,43 @@ func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
 
-	return &PostgresStorage{DB: db}, nil
+	return &PostgresStorage{
+		DB:           db,
+		Notifier:     NewTaskNotifier(),
+		connStr:      connStr,
+		stopDispatch: make(chan struct{}),
+	}, nil
 }
 
 // User methods
+// CreateUser добавляет пользователя. Возвращает ErrLoginTaken, если логин занят.
 func (s *PostgresStorage) CreateUser(ctx context.Context, user *models.User) error {
-	return s.DB.QueryRowContext(ctx,
-		"INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING id, created_at",
-		user.Login, user.PasswordHash).Scan(&user.ID, &user.CreatedAt)
+	err := s.DB.QueryRowContext(ctx,
+		"INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING id, role, created_at",
+		user.Login, user.PasswordHash).Scan(&user.ID, &user.Role, &user.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrLoginTaken
+	}
+	return err
 }
 
 func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
 	var user models.User
 	err := s.DB.QueryRowContext(ctx,
-		"SELECT id, login, password_hash, created_at FROM users WHERE login = $1",
-		login).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+		"SELECT id, login, password_hash, role, session_version, created_at FROM users WHERE login = $1",
+		login).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.Role, &user.SessionVersion, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *PostgresStorage) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT id, login, password_hash, role, session_version, created_at FROM users WHERE id = $1",
+		id).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.Role, &user.SessionVersion, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -50,27 +99,56 @@ func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (*mo
 // Expression methods
 func (s *PostgresStorage) CreateExpression(ctx context.Context, expr *models.Expression) error {
 	return s.DB.QueryRowContext(ctx,
-		"INSERT INTO expressions (user_id, expression, status) VALUES ($1, $2, $3) RETURNING id, created_at",
-		expr.UserID, expr.Expression, expr.Status).Scan(&expr.ID, &expr.CreatedAt)
+		"INSERT INTO expressions (user_id, expression, status, priority, org_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		expr.UserID, expr.Expression, expr.Status, expr.Priority, expr.OrgID).Scan(&expr.ID, &expr.CreatedAt)
 }
 
+// У выражений организации, автор которых удалён, user_id = 0
+const expressionColumns = `id, COALESCE(user_id, 0), expression, result, status, priority, org_id, created_at`
+
 func (s *PostgresStorage) GetExpressionByID(ctx context.Context, id int) (*models.Expression, error) {
 	var expr models.Expression
-	err := s.DB.QueryRowContext(ctx,
-		"SELECT id, user_id, expression, result, status, created_at FROM expressions WHERE id = $1",
-		id).Scan(&expr.ID, &expr.UserID, &expr.Expression, &expr.Result, &expr.Status, &expr.CreatedAt)
-	if err != nil {
+	row := s.DB.QueryRowContext(ctx, "SELECT "+expressionColumns+" FROM expressions WHERE id = $1", id)
+	if err := scanExpression(row, &expr); err != nil {
 		return nil, err
 	}
 	return &expr, nil
 }
 
+// GetUserExpressions возвращает выражения, отправленные пользователем, включая выражения организаций
 func (s *PostgresStorage) GetUserExpressions(ctx context.Context, userID int) ([]models.Expression, error) {
 	log.Printf("Executing query for user %d", userID)
+	return s.queryExpressions(ctx,
+		"SELECT "+expressionColumns+" FROM expressions WHERE user_id = $1 ORDER BY created_at DESC", userID)
+}
 
-	rows, err := s.DB.QueryContext(ctx,
-		"SELECT id, expression, result, status, created_at FROM expressions WHERE user_id = $1 ORDER BY created_at DESC",
-		userID)
+// GetOrgExpressions возвращает выражения организации orgID всех её участников
+func (s *PostgresStorage) GetOrgExpressions(ctx context.Context, orgID int) ([]models.Expression, error) {
+	return s.queryExpressions(ctx,
+		"SELECT "+expressionColumns+" FROM expressions WHERE org_id = $1 ORDER BY created_at DESC", orgID)
+}
+
+// CanViewExpression сообщает, может ли пользователь userID видеть выражение expr:
+// своё выражение или выражение организации, в которой он состоит с любой ролью
+func (s *PostgresStorage) CanViewExpression(ctx context.Context, userID int, expr *models.Expression) (bool, error) {
+	if expr.UserID == userID {
+		return true, nil
+	}
+	if expr.OrgID == nil {
+		return false, nil
+	}
+	_, err := s.GetMemberRole(ctx, *expr.OrgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *PostgresStorage) queryExpressions(ctx context.Context, query string, args ...any) ([]models.Expression, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Printf("Query error: %v", err)
 		return nil, err
@@ -80,13 +158,23 @@ func (s *PostgresStorage) GetUserExpressions(ctx context.Context, userID int) ([
 	var expressions []models.Expression
 	for rows.Next() {
 		var expr models.Expression
-		if err := rows.Scan(&expr.ID, &expr.Expression, &expr.Result, &expr.Status, &expr.CreatedAt); err != nil {
+		if err := scanExpression(rows, &expr); err != nil {
 			return nil, err
 		}
 		expressions = append(expressions, expr)
 	}
 
-	return expressions, nil
+	return expressions, rows.Err()
+}
+
+func scanExpression(row rowScanner, expr *models.Expression) error {
+	var orgID sql.NullInt64
+	if err := row.Scan(&expr.ID, &expr.UserID, &expr.Expression, &expr.Result, &expr.Status,
+		&expr.Priority, &orgID, &expr.CreatedAt); err != nil {
+		return err
+	}
+	expr.OrgID = nullIntPtr(orgID)
+	return nil
 }
 
 func (s *PostgresStorage) UpdateExpressionResult(ctx context.Context, id int, result float64) error {
@@ -105,8 +193,8 @@ func (s *PostgresStorage) DeleteExpression(ctx context.Context, id int) error {
 func (s *PostgresStorage) CreateTask(ctx context.Context, task *models.Task) error {
 	_, err := s.DB.ExecContext(ctx,
 		`INSERT INTO tasks 
-         (id, expression_id, arg1, arg2, operation, operation_time, status, result, depends_on) 
-         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+         (id, expression_id, arg1, arg2, operation, operation_time, status, result, depends_on, priority) 
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
 		task.ID,
 		task.ExpressionID,
 		task.Arg1,
@@ -116,6 +204,7 @@ func (s *PostgresStorage) CreateTask(ctx context.Context, task *models.Task) err
 		task.Status,
 		task.Result,
 		pq.Array(task.DependsOn),
+		task.Priority,
 	)
 	return err
 }
@@ -126,8 +215,8 @@ func (s *PostgresStorage) GetTaskByID(ctx context.Context, id string) (*models.T
 	var result sql.NullFloat64
 
 	err := s.DB.QueryRowContext(ctx,
-		"SELECT id, expression_id, arg1, arg2, operation, operation_time, status, result, depends_on FROM tasks WHERE id = $1",
-		id).Scan(&task.ID, &task.ExpressionID, &task.Arg1, &task.Arg2, &task.Operation, &task.OperationTime, &task.Status, &result, &dependsOn)
+		"SELECT id, expression_id, arg1, arg2, operation, operation_time, status, result, depends_on, priority FROM tasks WHERE id = $1",
+		id).Scan(&task.ID, &task.ExpressionID, &task.Arg1, &task.Arg2, &task.Operation, &task.OperationTime, &task.Status, &result, &dependsOn, &task.Priority)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +238,7 @@ func (s *PostgresStorage) GetTaskByID(ctx context.Context, id string) (*models.T
 
 func (s *PostgresStorage) GetPendingTasks(ctx context.Context) ([]models.Task, error) {
 	rows, err := s.DB.QueryContext(ctx,
-		"SELECT id, expression_id, arg1, arg2, operation, operation_time, depends_on FROM tasks WHERE status = 'pending'")
+		"SELECT id, expression_id, arg1, arg2, operation, operation_time, depends_on, priority FROM tasks WHERE status = 'pending'")
 	if err != nil {
 		log.Printf("Query error: %v", err)
 		return nil, err
@@ -161,7 +250,7 @@ func (s *PostgresStorage) GetPendingTasks(ctx context.Context) ([]models.Task, e
 		var task models.Task
 		var dependsOn pq.StringArray
 
-		if err := rows.Scan(&task.ID, &task.ExpressionID, &task.Arg1, &task.Arg2, &task.Operation, &task.OperationTime, &dependsOn); err != nil {
+		if err := rows.Scan(&task.ID, &task.ExpressionID, &task.Arg1, &task.Arg2, &task.Operation, &task.OperationTime, &dependsOn, &task.Priority); err != nil {
 			return nil, err
 		}
 
@@ -178,17 +267,33 @@ func (s *PostgresStorage) GetPendingTasks(ctx context.Context) ([]models.Task, e
 	return tasks, nil
 }
 
-func (s *PostgresStorage) UpdateTaskResult(ctx context.Context, id string, result float64) error {
-	_, err := s.DB.ExecContext(ctx,
-		"UPDATE tasks SET result = $1, status = 'completed' WHERE id = $2",
-		result, id)
-	return err
+// UpdateTaskResult сохраняет результат задачи, выданной агенту agentID.
+// Возвращает ErrTaskNotAssigned, если задача у этого агента не в работе.
+func (s *PostgresStorage) UpdateTaskResult(ctx context.Context, id, agentID string, result float64) error {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE tasks SET result = $1, status = 'completed', agent_id = NULL WHERE id = $2 AND agent_id = $3 AND status = 'pending'",
+		result, id, agentID)
+	if err != nil {
+		return err
+	}
+	return requireAssigned(res)
+}
+
+func requireAssigned(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotAssigned
+	}
+	return nil
 }
 
 func (s *PostgresStorage) GetTasksByExpressionID(ctx context.Context, expressionID int) ([]*models.Task, error) {
 	query := `
         SELECT id, expression_id, arg1, arg2, operation, 
-               operation_time, status, result, depends_on
+               operation_time, status, result, depends_on, priority
         FROM tasks 
         WHERE expression_id = $1
     `
@@ -215,6 +320,7 @@ func (s *PostgresStorage) GetTasksByExpressionID(ctx context.Context, expression
 			&task.Status,
 			&result,
 			&dependsOn,
+			&task.Priority,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan task: %w", err)
@@ -259,7 +365,7 @@ func (s *PostgresStorage) CheckDependenciesCompleted(ctx context.Context, taskID
 func (s *PostgresStorage) GetDependentTasks(ctx context.Context, taskID string) ([]*models.Task, error) {
 	query := `
         SELECT id, expression_id, arg1, arg2, operation, 
-               operation_time, status, result, depends_on
+               operation_time, status, result, depends_on, priority
         FROM tasks 
         WHERE $1 = ANY(depends_on) AND status = 'pending'
     `
@@ -286,6 +392,7 @@ func (s *PostgresStorage) GetDependentTasks(ctx context.Context, taskID string)
 			&task.Status,
 			&result,
 			&dependsOn,
+			&task.Priority,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan task: %w", err)
@@ -314,8 +421,11 @@ func (s *PostgresStorage) GetDependentTasks(ctx context.Context, taskID string)
 
 func (s *PostgresStorage) AddTaskToQueue(ctx context.Context, taskID string) error {
 	var status string
+	var priority, userID int
 	err := s.DB.QueryRowContext(ctx,
-		"SELECT status FROM tasks WHERE id = $1", taskID).Scan(&status)
+		`SELECT t.status, t.priority, COALESCE(e.user_id, -e.org_id, 0)
+         FROM tasks t JOIN expressions e ON e.id = t.expression_id
+         WHERE t.id = $1`, taskID).Scan(&status, &priority, &userID)
 	if err != nil {
 		return fmt.Errorf("task not found: %w", err)
 	}
@@ -324,37 +434,178 @@ func (s *PostgresStorage) AddTaskToQueue(ctx context.Context, taskID string) err
 		return fmt.Errorf("task %s has invalid status: %s", taskID, status)
 	}
 
+	// задача в очереди ни за кем не закреплена
+	if _, err := s.DB.ExecContext(ctx, "UPDATE tasks SET agent_id = NULL WHERE id = $1", taskID); err != nil {
+		return fmt.Errorf("failed to release task: %w", err)
+	}
+
 	_, err = s.DB.ExecContext(ctx,
-		`INSERT INTO task_queue (task_id) VALUES ($1)
+		`INSERT INTO task_queue (task_id, priority, user_id) VALUES ($1, $2, $3)
          ON CONFLICT (task_id) DO NOTHING`,
-		taskID)
+		taskID, priority, userID)
 	if err != nil {
 		return fmt.Errorf("failed to insert into queue: %w", err)
 	}
 
+	// задача уже в очереди, поэтому ошибка NOTIFY не фатальна: другие реплики найдут её по таймауту
+	if _, err := s.DB.ExecContext(ctx, "SELECT pg_notify($1, $2)", taskQueueChannel, taskID); err != nil {
+		log.Printf("Failed to notify about task %s: %v", taskID, err)
+	}
+
+	s.Notifier.Notify()
 	return nil
 }
 
-func (s *PostgresStorage) GetNextTaskFromQueue(ctx context.Context) (*models.Task, error) {
+// RequeueTask возвращает в очередь задачу, которую агент agentID не смог посчитать.
+// Возвращает ErrTaskNotAssigned, если задача у этого агента не в работе.
+func (s *PostgresStorage) RequeueTask(ctx context.Context, taskID, agentID string) error {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE tasks SET agent_id = NULL WHERE id = $1 AND agent_id = $2 AND status = 'pending'",
+		taskID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to release task: %w", err)
+	}
+	if err := requireAssigned(res); err != nil {
+		return err
+	}
+	return s.AddTaskToQueue(ctx, taskID)
+}
+
+// FailTask отмечает задачу, которую агент agentID не может посчитать (например, деление на ноль),
+// как failed. Выражение без неё не вычислить, поэтому оно тоже получает статус failed, а остальные
+// его невычисленные задачи отменяются и убираются из очереди.
+// Возвращает ErrTaskNotAssigned, если задача у этого агента не в работе.
+func (s *PostgresStorage) FailTask(ctx context.Context, taskID, agentID string) error {
 	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Failed to begin transaction: %v", err)
-		return nil, err
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	var taskID string
-	err = tx.QueryRowContext(ctx, `
-        SELECT task_id FROM task_queue LIMIT 1 FOR UPDATE
-    `).Scan(&taskID)
+	var expressionID int
+	err = tx.QueryRowContext(ctx,
+		"UPDATE tasks SET status = 'failed', agent_id = NULL WHERE id = $1 AND agent_id = $2 AND status = 'pending' RETURNING expression_id",
+		taskID, agentID).Scan(&expressionID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			log.Println("No tasks found in task_queue")
-			return nil, nil
+			return ErrTaskNotAssigned
+		}
+		return fmt.Errorf("failed to fail task %s: %w", taskID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+        DELETE FROM task_queue
+        WHERE task_id IN (SELECT id FROM tasks WHERE expression_id = $1 AND status = 'pending')`,
+		expressionID); err != nil {
+		return fmt.Errorf("failed to dequeue tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tasks SET status = 'cancelled' WHERE expression_id = $1 AND status = 'pending'",
+		expressionID); err != nil {
+		return fmt.Errorf("failed to cancel tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE expressions SET status = 'failed' WHERE id = $1 AND status = 'pending'",
+		expressionID); err != nil {
+		return fmt.Errorf("failed to fail expression: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// PurgeTaskQueue очищает очередь и отменяет все невычисленные задачи вместе с их выражениями,
+// чтобы они не вернулись в очередь при перезапуске. Возвращает число отменённых выражений.
+// Задачи, которые агенты уже взяли, досчитываются, но зависящие от них задачи не запустятся.
+func (s *PostgresStorage) PurgeTaskQueue(ctx context.Context) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_queue"); err != nil {
+		return 0, fmt.Errorf("failed to clear task queue: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET status = 'cancelled' WHERE status = 'pending'"); err != nil {
+		return 0, fmt.Errorf("failed to cancel tasks: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE expressions SET status = 'cancelled' WHERE status = 'pending'")
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel expressions: %w", err)
+	}
+	cancelled, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return cancelled, nil
+}
+
+// ListenTaskQueue подписывается на NOTIFY о новых задачах от всех реплик оркестратора
+// и будит локальных ожидающих через Notifier. Слушатель работает до отмены ctx.
+func (s *PostgresStorage) ListenTaskQueue(ctx context.Context) error {
+	listener := pq.NewListener(s.connStr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Task queue listener error (event %d): %v", ev, err)
 		}
+	})
+	if err := listener.Listen(taskQueueChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", taskQueueChannel, err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// nil приходит после переподключения, когда уведомления могли потеряться,
+				// поэтому будим ожидающих в любом случае
+				s.Notifier.Notify()
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// GetNextTaskFromQueue забирает из очереди следующую задачу с одной из операций operations
+// (nil — с любой операцией), чтобы агент не получил задачу, которую не умеет вычислять,
+// и закрепляет её за агентом agentID до ответа.
+func (s *PostgresStorage) GetNextTaskFromQueue(ctx context.Context, agentID string, operations []string) (*models.Task, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// пользователь выбирается по справедливой доле, среди его задач — задача с наибольшим приоритетом
+	turn, err := nextFairTurn(ctx, tx, operations)
+	if err != nil {
 		log.Printf("Error selecting from task_queue: %v", err)
 		return nil, err
 	}
+	if turn == nil {
+		log.Println("No tasks found in task_queue")
+		return nil, nil
+	}
+	taskID := turn.taskID
+
+	if err := turn.commit(ctx, tx); err != nil {
+		log.Printf("Error updating scheduler state: %v", err)
+		return nil, err
+	}
 
 	_, err = tx.ExecContext(ctx, `
         DELETE FROM task_queue WHERE task_id = $1
@@ -364,6 +615,13 @@ func (s *PostgresStorage) GetNextTaskFromQueue(ctx context.Context) (*models.Tas
 		return nil, err
 	}
 
+	// результат примут только от агента, получившего задачу
+	_, err = tx.ExecContext(ctx, "UPDATE tasks SET agent_id = $2 WHERE id = $1", taskID, agentID)
+	if err != nil {
+		log.Printf("Error assigning task %s to agent %s: %v", taskID, agentID, err)
+		return nil, err
+	}
+
 	log.Printf("Extracted task ID from queue: %s", taskID)
 
 	var task models.Task
@@ -372,14 +630,14 @@ func (s *PostgresStorage) GetNextTaskFromQueue(ctx context.Context) (*models.Tas
 
 	err = tx.QueryRowContext(ctx, `
         SELECT id, expression_id, arg1, arg2, operation, 
-               operation_time, status, result, depends_on 
+               operation_time, status, result, depends_on, priority 
         FROM tasks WHERE id = $1`,
 		taskID).Scan(
 		&task.ID, &task.ExpressionID,
 		&task.Arg1, &task.Arg2,
 		&task.Operation, &task.OperationTime,
 		&task.Status, &result,
-		&dependsOn,
+		&dependsOn, &task.Priority,
 	)
 
 	if err != nil {
@@ -405,9 +663,67 @@ func (s *PostgresStorage) GetNextTaskFromQueue(ctx context.Context) (*models.Tas
 		return nil, err
 	}
 
+	// задача уже выдана: отставшее общее время лишь ненадолго ослабит справедливость
+	if err := s.advanceSystemTime(ctx, turn.start); err != nil {
+		log.Printf("Error updating scheduler state: %v", err)
+	}
+
 	return &task, nil
 }
 
+// WaitNextTaskFromQueue ждёт появления задачи в очереди не дольше wait.
+// Если задача так и не появилась, возвращает nil без ошибки.
+// После StopDispatch сразу возвращает ErrShuttingDown.
+func (s *PostgresStorage) WaitNextTaskFromQueue(ctx context.Context, wait time.Duration, agentID string, operations []string) (*models.Task, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		select {
+		case <-s.stopDispatch:
+			return nil, ErrShuttingDown
+		default:
+		}
+
+		// подписываемся до проверки очереди, чтобы не пропустить AddTaskToQueue между ними
+		ready := s.Notifier.Wait()
+
+		task, err := s.GetNextTaskFromQueue(ctx, agentID, operations)
+		if err != nil || task != nil {
+			return task, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ready:
+			timer.Stop()
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-s.stopDispatch:
+			timer.Stop()
+			return nil, ErrShuttingDown
+		}
+	}
+}
+
+// DispatchStopped закрывается после StopDispatch
+func (s *PostgresStorage) DispatchStopped() <-chan struct{} {
+	return s.stopDispatch
+}
+
+// StopDispatch прекращает выдачу задач через WaitNextTaskFromQueue и освобождает
+// всех, кто сейчас ждёт задачу. Вызывается при остановке оркестратора.
+func (s *PostgresStorage) StopDispatch() {
+	s.stopDispatchOnce.Do(func() {
+		close(s.stopDispatch)
+	})
+}
+
 func (s *PostgresStorage) Close() error {
 	return s.DB.Close()
 }
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRefreshTokenReused — предъявлен уже использованный refresh-токен. Скорее всего, токен
+// украден, поэтому вся цепочка обновлений отозвана.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// CreateRefreshToken сохраняет первый refresh-токен новой цепочки (при логине).
+func (s *PostgresStorage) CreateRefreshToken(ctx context.Context, userID int, familyID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+		userID, familyID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken погашает refresh-токен oldHash и выпускает вместо него newHash в той же цепочке.
+// Возвращает sql.ErrNoRows, если токена нет, он истёк или отозван, и ErrRefreshTokenReused,
+// если токен уже был погашен.
+func (s *PostgresStorage) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		userID         int
+		familyID       string
+		used, revoked  sql.NullTime
+		tokenExpiresAt time.Time
+	)
+	err = tx.QueryRowContext(ctx, `
+        SELECT user_id, family_id, used_at, revoked_at, expires_at
+        FROM refresh_tokens WHERE token_hash = $1
+        FOR UPDATE`,
+		oldHash).Scan(&userID, &familyID, &used, &revoked, &tokenExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	if revoked.Valid || !tokenExpiresAt.After(time.Now()) {
+		return 0, sql.ErrNoRows
+	}
+	if used.Valid {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL",
+			familyID); err != nil {
+			return 0, fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return 0, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1",
+		oldHash); err != nil {
+		return 0, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+		userID, familyID, newHash, expiresAt); err != nil {
+		return 0, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// RevokeRefreshTokenFamily отзывает цепочку, которой принадлежит refresh-токен tokenHash
+// пользователя userID. Чужие и неизвестные токены молча пропускаются.
+func (s *PostgresStorage) RevokeRefreshTokenFamily(ctx context.Context, userID int, tokenHash string) error {
+	_, err := s.DB.ExecContext(ctx, `
+        UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+        WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2)
+          AND revoked_at IS NULL`,
+		tokenHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
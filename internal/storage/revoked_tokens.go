@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Сколько реплика доверяет закэшированному ответу «токен не отозван». Отзыв на этой реплике
+// действует сразу, на других — не позже чем через revocationCacheTTL.
+const revocationCacheTTL = 10 * time.Second
+
+type tokenState struct {
+	userID    int
+	revoked   bool
+	checkedAt time.Time
+	expiresAt time.Time
+}
+
+// revocationCache избавляет от запроса в БД на каждый запрос пользователя.
+// Нулевое значение готово к работе.
+type revocationCache struct {
+	mu        sync.Mutex
+	tokens    map[string]tokenState
+	lastSweep time.Time
+}
+
+func (c *revocationCache) lookup(jti string, now time.Time) (revoked, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st, ok := c.tokens[jti]
+	if !ok {
+		return false, false
+	}
+	if st.revoked {
+		return true, true
+	}
+	return false, now.Sub(st.checkedAt) < revocationCacheTTL
+}
+
+func (c *revocationCache) store(jti string, st tokenState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]tokenState)
+	}
+	c.tokens[jti] = st
+
+	// истёкшие токены всё равно не пройдут проверку подписи, хранить их незачем
+	if st.checkedAt.Sub(c.lastSweep) >= revocationCacheTTL {
+		for id, t := range c.tokens {
+			if !t.expiresAt.After(st.checkedAt) {
+				delete(c.tokens, id)
+			}
+		}
+		c.lastSweep = st.checkedAt
+	}
+}
+
+func (c *revocationCache) forgetUser(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, t := range c.tokens {
+		if t.userID == userID {
+			delete(c.tokens, id)
+		}
+	}
+}
+
+// TokenRevoked сообщает, отозван ли токен доступа jti пользователя userID: сам по себе
+// (выход) или вместе со всеми сессиями пользователя, если его версия сессий sessionVersion
+// устарела. Токены удалённых пользователей тоже считаются отозванными.
+func (s *PostgresStorage) TokenRevoked(ctx context.Context, jti string, userID, sessionVersion int, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	if revoked, ok := s.revocations.lookup(jti, now); ok {
+		return revoked, nil
+	}
+
+	var (
+		tokenRevoked   bool
+		currentVersion sql.NullInt64
+	)
+	err := s.DB.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1),
+               (SELECT session_version FROM users WHERE id = $2)`,
+		jti, userID).Scan(&tokenRevoked, &currentVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	revoked := tokenRevoked || !currentVersion.Valid || int64(sessionVersion) < currentVersion.Int64
+
+	s.revocations.store(jti, tokenState{userID: userID, revoked: revoked, checkedAt: now, expiresAt: expiresAt})
+	return revoked, nil
+}
+
+// RevokeToken отзывает токен доступа jti до его истечения (выход из системы).
+func (s *PostgresStorage) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `
+        INSERT INTO revoked_tokens (jti, user_id, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING`,
+		jti, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	s.revocations.store(jti, tokenState{userID: userID, revoked: true, checkedAt: time.Now(), expiresAt: expiresAt})
+
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions отзывает все выданные пользователю токены доступа, refresh-токены
+// и личные API-ключи. Возвращает sql.ErrNoRows, если пользователя нет.
+func (s *PostgresStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := revokeSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := revokeAPIKeys(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.revocations.forgetUser(userID)
+	return nil
+}
+
+// revokeSessions увеличивает версию сессий пользователя и отзывает его refresh-токены.
+// После коммита нужно вызвать s.revocations.forgetUser.
+func revokeSessions(ctx context.Context, tx *sql.Tx, userID int) error {
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET session_version = session_version + 1 WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL",
+		userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// revokeAPIKeys отзывает личные API-ключи пользователя: по ним можно работать и без токена
+func revokeAPIKeys(ctx context.Context, tx *sql.Tx, userID int) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL",
+		userID); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/lib/pq"
+)
+
+// Вес пользователя, для которого не задано значение в user_scheduling
+const DefaultUserWeight = 1
+
+// orgSchedulingID — ключ в task_queue и user_scheduling, под которым планируются задачи
+// выражений организации, автор которых удалён. У каждой организации своя доля с весом
+// по умолчанию, а не одна общая доля на все выражения без автора.
+func orgSchedulingID(orgID int) int {
+	return -orgID
+}
+
+// fairTurn — выбор пользователя, чья задача будет выдана следующей.
+//
+// Используется start-time fair queuing: у каждого пользователя есть виртуальное время,
+// которое растёт на 1/weight за каждую выданную задачу, и задачу получает пользователь
+// с наименьшим временем. Время простаивавших пользователей подтягивается к общему времени
+// планировщика, чтобы за время простоя они не накопили права забрать всю очередь.
+//
+// Пользователь и его задача выбираются одним запросом с SKIP LOCKED: реплики, выдающие задачи
+// одновременно, пропускают уже взятые строки и переходят к следующей задаче или следующему
+// пользователю, а время пользователя атомарно вырастет на каждую выданную задачу.
+//
+// Приоритет с учётом ожидания (priorityAgingInterval) упорядочивает задачи одного пользователя
+// и решает, кто из пользователей с равным временем получит задачу первым; обогнать пользователя
+// с меньшим временем приоритет не может, иначе высокий приоритет отменял бы справедливую долю.
+type fairTurn struct {
+	taskID string
+	userID int
+	start  float64
+	weight int
+}
+
+// nextFairTurn выбирает и блокирует в очереди следующую задачу. operations ограничивает выбор
+// задачами с этими операциями; nil — любые операции.
+func nextFairTurn(ctx context.Context, tx *sql.Tx, operations []string) (*fairTurn, error) {
+	var systemTime float64
+	err := tx.QueryRowContext(ctx,
+		"SELECT virtual_time FROM scheduler_state WHERE id = 1").Scan(&systemTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduler state: %w", err)
+	}
+
+	var turn fairTurn
+	err = tx.QueryRowContext(ctx, `
+        SELECT tq.task_id, tq.user_id,
+               GREATEST(COALESCE(us.virtual_time, 0), $1) AS start,
+               COALESCE(us.weight, $2)
+        FROM task_queue tq
+        JOIN tasks t ON t.id = tq.task_id
+        LEFT JOIN user_scheduling us ON us.user_id = tq.user_id
+        WHERE $3::text[] IS NULL OR t.operation = ANY($3)
+        ORDER BY start,
+                 tq.priority + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - tq.created_at)) / $4 DESC,
+                 tq.created_at, tq.user_id
+        LIMIT 1 FOR UPDATE OF tq SKIP LOCKED`,
+		systemTime, DefaultUserWeight, pq.StringArray(operations), priorityAgingInterval.Seconds()).
+		Scan(&turn.taskID, &turn.userID, &turn.start, &turn.weight)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &turn, nil
+}
+
+// commit продвигает время пользователя на выданную задачу. Время считается от текущего
+// значения строки, а не от прочитанного при выборе, поэтому одновременные выдачи не теряются.
+func (t *fairTurn) commit(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO user_scheduling (user_id, weight, virtual_time, dispatched)
+        VALUES ($1, $2, $3::double precision + 1.0 / $2, 1)
+        ON CONFLICT (user_id) DO UPDATE
+        SET virtual_time = GREATEST(user_scheduling.virtual_time, $3) + 1.0 / user_scheduling.weight,
+            dispatched = user_scheduling.dispatched + 1`,
+		t.userID, t.weight, t.start)
+	if err != nil {
+		return fmt.Errorf("failed to update user %d virtual time: %w", t.userID, err)
+	}
+	return nil
+}
+
+// advanceSystemTime подтягивает общее время планировщика к началу выданной задачи.
+// Выполняется отдельным запросом после выдачи, чтобы строка не блокировалась на всю транзакцию;
+// GREATEST не даёт времени откатиться назад при гонке реплик.
+func (s *PostgresStorage) advanceSystemTime(ctx context.Context, start float64) error {
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE scheduler_state SET virtual_time = GREATEST(virtual_time, $1) WHERE id = 1", start)
+	if err != nil {
+		return fmt.Errorf("failed to update scheduler virtual time: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ListUserScheduling(ctx context.Context) ([]models.UserScheduling, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT u.id, u.login,
+               COALESCE(us.weight, $1),
+               COALESCE(us.virtual_time, 0),
+               COALESCE(us.dispatched, 0),
+               (SELECT COUNT(*) FROM task_queue q WHERE q.user_id = u.id)
+        FROM users u
+        LEFT JOIN user_scheduling us ON us.user_id = u.id
+        ORDER BY u.id`,
+		DefaultUserWeight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user scheduling: %w", err)
+	}
+	defer rows.Close()
+
+	var result []models.UserScheduling
+	for rows.Next() {
+		var us models.UserScheduling
+		if err := rows.Scan(&us.UserID, &us.Login, &us.Weight, &us.VirtualTime, &us.Dispatched, &us.Queued); err != nil {
+			return nil, fmt.Errorf("failed to scan user scheduling: %w", err)
+		}
+		result = append(result, us)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return result, nil
+}
+
+// SetUserWeight задаёт вес пользователя в справедливом планировании.
+// Возвращает sql.ErrNoRows, если пользователя нет.
+func (s *PostgresStorage) SetUserWeight(ctx context.Context, userID, weight int) error {
+	var id int
+	return s.DB.QueryRowContext(ctx, `
+        INSERT INTO user_scheduling (user_id, weight)
+        SELECT id, $2 FROM users WHERE id = $1
+        ON CONFLICT (user_id) DO UPDATE SET weight = EXCLUDED.weight
+        RETURNING user_id`,
+		userID, weight).Scan(&id)
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+)
+
+func (s *PostgresStorage) ListUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, login, role, created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Login, &user.Role, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return users, nil
+}
+
+// SetUserRole меняет роль пользователя и отзывает его сессии, чтобы старая роль не действовала
+// до истечения уже выданных токенов. API-ключи не отзываются: они всегда действуют с ролью user.
+// Возвращает sql.ErrNoRows, если пользователя нет.
+func (s *PostgresStorage) SetUserRole(ctx context.Context, userID int, role string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, userID); err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	if err := revokeSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.revocations.forgetUser(userID)
+	return nil
+}
+
+// SetPassword меняет хэш пароля пользователя и отзывает все его сессии и API-ключи.
+// Возвращает sql.ErrNoRows, если пользователя нет.
+func (s *PostgresStorage) SetPassword(ctx context.Context, userID int, passwordHash string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	if err := revokeSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := revokeAPIKeys(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.revocations.forgetUser(userID)
+	return nil
+}
+
+// DeleteUser удаляет пользователя вместе с его личными выражениями, задачами и записями в очереди.
+// Выражения организаций остаются в организациях без автора, организации, где он был единственным
+// участником, удаляются. Внешние ключи исходной схемы не каскадные, поэтому строки удаляются
+// от очереди к пользователю; ключи, токены, лимиты и членство удаляются каскадом.
+// Возвращает sql.ErrNoRows, если пользователя нет, и ErrLastOwner, если он единственный
+// владелец организации с другими участниками.
+// Задачи, которые агенты уже взяли, досчитываются, но их результаты будут отклонены.
+func (s *PostgresStorage) DeleteUser(ctx context.Context, userID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var login string
+	if err := tx.QueryRowContext(ctx,
+		"SELECT login FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&login); err != nil {
+		return err
+	}
+
+	// блокируем организации пользователя, как при изменении состава участников
+	if _, err := tx.ExecContext(ctx, `
+        SELECT 1 FROM organizations
+        WHERE id IN (SELECT org_id FROM organization_members WHERE user_id = $1)
+        ORDER BY id FOR UPDATE`,
+		userID); err != nil {
+		return fmt.Errorf("failed to lock organizations: %w", err)
+	}
+
+	var lastOwner bool
+	if err := tx.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM organization_members m
+            WHERE m.user_id = $1 AND m.role = $2
+              AND NOT EXISTS (SELECT 1 FROM organization_members o
+                              WHERE o.org_id = m.org_id AND o.user_id <> $1 AND o.role = $2)
+              AND EXISTS (SELECT 1 FROM organization_members o
+                          WHERE o.org_id = m.org_id AND o.user_id <> $1))`,
+		userID, models.OrgRoleOwner).Scan(&lastOwner); err != nil {
+		return fmt.Errorf("failed to check organization owners: %w", err)
+	}
+	if lastOwner {
+		return ErrLastOwner
+	}
+
+	// организации, где пользователь единственный участник, удаляются вместе с ним
+	var soleOrgs []int
+	rows, err := tx.QueryContext(ctx, `
+        SELECT org_id FROM organization_members GROUP BY org_id
+        HAVING COUNT(*) = 1 AND bool_and(user_id = $1)`,
+		userID)
+	if err != nil {
+		return fmt.Errorf("failed to query organizations: %w", err)
+	}
+	for rows.Next() {
+		var orgID int
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan organization: %w", err)
+		}
+		soleOrgs = append(soleOrgs, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+	for _, orgID := range soleOrgs {
+		if err := deleteOrganization(ctx, tx, orgID); err != nil {
+			return err
+		}
+	}
+
+	steps := []struct {
+		query, what string
+		args        []any
+	}{
+		{`DELETE FROM task_queue WHERE task_id IN (
+              SELECT t.id FROM tasks t JOIN expressions e ON e.id = t.expression_id
+              WHERE e.user_id = $1 AND e.org_id IS NULL)`,
+			"queued tasks", []any{userID}},
+		{`DELETE FROM tasks WHERE expression_id IN (
+              SELECT id FROM expressions WHERE user_id = $1 AND org_id IS NULL)`,
+			"tasks", []any{userID}},
+		{"DELETE FROM expressions WHERE user_id = $1 AND org_id IS NULL", "expressions", []any{userID}},
+		// задачи выражений без автора планируются от имени организации (orgSchedulingID)
+		{`UPDATE task_queue q SET user_id = -e.org_id
+              FROM tasks t JOIN expressions e ON e.id = t.expression_id
+              WHERE t.id = q.task_id AND e.user_id = $1`,
+			"queued organization tasks", []any{userID}},
+		{"UPDATE expressions SET user_id = NULL WHERE user_id = $1", "organization expressions", []any{userID}},
+		{"DELETE FROM user_scheduling WHERE user_id = $1", "scheduling state", []any{userID}},
+		{"DELETE FROM submission_counters WHERE user_id = $1", "submission counters", []any{userID}},
+		{"DELETE FROM login_failures WHERE scope = $1 AND key = $2", "login failures", []any{LoginScope, login}},
+		{"DELETE FROM users WHERE id = $1", "user", []any{userID}},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, step.args...); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", step.what, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.revocations.forgetUser(userID)
+	return nil
+}
+
+// GetUserUsage возвращает статистику пользователя: число выражений по статусам и действующих API-ключей
+func (s *PostgresStorage) GetUserUsage(ctx context.Context, userID int) (*models.UserUsage, error) {
+	usage := models.UserUsage{Expressions: map[string]int{}}
+
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT status, COUNT(*) FROM expressions WHERE user_id = $1 GROUP BY status", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count expressions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan expression count: %w", err)
+		}
+		usage.Expressions[status] = count
+		usage.TotalExpressions += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if err := s.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL",
+		userID).Scan(&usage.ActiveAPIKeys); err != nil {
+		return nil, fmt.Errorf("failed to count API keys: %w", err)
+	}
+
+	return &usage, nil
+}
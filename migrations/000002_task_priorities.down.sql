@@ -0,0 +1,3 @@
+ALTER TABLE public.task_queue DROP COLUMN IF EXISTS priority;
+ALTER TABLE public.tasks DROP COLUMN IF EXISTS priority;
+ALTER TABLE public.expressions DROP COLUMN IF EXISTS priority;
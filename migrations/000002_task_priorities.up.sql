@@ -0,0 +1,3 @@
+ALTER TABLE public.expressions ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 5;
+ALTER TABLE public.tasks ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 5;
+ALTER TABLE public.task_queue ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 5;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.scheduler_state;
+DROP TABLE IF EXISTS public.user_scheduling;
+
+DROP INDEX IF EXISTS public.task_queue_user_id_idx;
+ALTER TABLE public.task_queue DROP COLUMN IF EXISTS user_id;
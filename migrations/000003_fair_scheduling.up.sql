@@ -0,0 +1,29 @@
+ALTER TABLE public.task_queue ADD COLUMN IF NOT EXISTS user_id integer NOT NULL DEFAULT 0;
+
+UPDATE public.task_queue q
+SET user_id = COALESCE(e.user_id, 0)
+FROM public.tasks t
+JOIN public.expressions e ON e.id = t.expression_id
+WHERE t.id = q.task_id;
+
+CREATE INDEX IF NOT EXISTS task_queue_user_id_idx ON public.task_queue (user_id);
+
+-- USER_SCHEDULING TABLE: вес и виртуальное время пользователя для справедливой выдачи задач
+CREATE TABLE IF NOT EXISTS public.user_scheduling (
+    user_id integer NOT NULL,
+    weight integer NOT NULL DEFAULT 1,
+    virtual_time double precision NOT NULL DEFAULT 0,
+    dispatched bigint NOT NULL DEFAULT 0,
+    CONSTRAINT user_scheduling_pkey PRIMARY KEY (user_id),
+    CONSTRAINT user_scheduling_weight_check CHECK (weight > 0)
+);
+
+-- SCHEDULER_STATE TABLE: общее виртуальное время планировщика, одна строка
+CREATE TABLE IF NOT EXISTS public.scheduler_state (
+    id smallint NOT NULL DEFAULT 1,
+    virtual_time double precision NOT NULL DEFAULT 0,
+    CONSTRAINT scheduler_state_pkey PRIMARY KEY (id),
+    CONSTRAINT scheduler_state_single_row CHECK (id = 1)
+);
+
+INSERT INTO public.scheduler_state (id) VALUES (1) ON CONFLICT (id) DO NOTHING;
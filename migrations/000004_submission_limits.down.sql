@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.expressions_user_id_status_idx;
+DROP TABLE IF EXISTS public.submission_counters;
+DROP TABLE IF EXISTS public.user_limits;
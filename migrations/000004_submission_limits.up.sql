@@ -0,0 +1,20 @@
+-- USER_LIMITS TABLE: индивидуальные лимиты пользователя, NULL — значение по умолчанию
+CREATE TABLE IF NOT EXISTS public.user_limits (
+    user_id integer NOT NULL,
+    submissions_per_minute integer,
+    max_pending integer,
+    max_tasks_per_expression integer,
+    max_expression_length integer,
+    CONSTRAINT user_limits_pkey PRIMARY KEY (user_id),
+    CONSTRAINT user_limits_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+-- SUBMISSION_COUNTERS TABLE: число отправленных выражений в минутном окне
+CREATE TABLE IF NOT EXISTS public.submission_counters (
+    user_id integer NOT NULL,
+    window_start timestamptz NOT NULL,
+    count integer NOT NULL DEFAULT 0,
+    CONSTRAINT submission_counters_pkey PRIMARY KEY (user_id, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS expressions_user_id_status_idx ON public.expressions (user_id, status);
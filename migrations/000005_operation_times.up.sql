@@ -0,0 +1,8 @@
+-- OPERATION_TIMES TABLE: время выполнения операций, заданное через админский API
+CREATE TABLE IF NOT EXISTS public.operation_times (
+    operation varchar(10) NOT NULL,
+    duration_ms integer NOT NULL,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT operation_times_pkey PRIMARY KEY (operation),
+    CONSTRAINT operation_times_duration_check CHECK (duration_ms >= 0)
+);
@@ -0,0 +1,12 @@
+-- AGENTS TABLE: зарегистрированные агенты, их возможности и время последнего heartbeat
+CREATE TABLE IF NOT EXISTS public.agents (
+    id varchar(64) NOT NULL,
+    hostname varchar(255) NOT NULL DEFAULT '',
+    version varchar(64) NOT NULL DEFAULT '',
+    operations text[] NOT NULL,
+    concurrency integer NOT NULL DEFAULT 1,
+    registered_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    last_seen timestamptz DEFAULT CURRENT_TIMESTAMP,
+    tasks_completed bigint NOT NULL DEFAULT 0,
+    CONSTRAINT agents_pkey PRIMARY KEY (id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE public.agents DROP COLUMN IF EXISTS agent_key_id;
+DROP TABLE IF EXISTS public.agent_keys;
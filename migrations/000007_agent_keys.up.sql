@@ -0,0 +1,15 @@
+-- AGENT_KEYS TABLE: API-ключи агентов, выданные администратором; хранится только SHA-256 ключа
+CREATE TABLE IF NOT EXISTS public.agent_keys (
+    id serial4 NOT NULL,
+    name varchar(255) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash char(64) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    CONSTRAINT agent_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT agent_keys_key_hash_key UNIQUE (key_hash)
+);
+
+-- агент привязан к ключу, с которым зарегистрировался: чужой ключ не может говорить от его имени
+ALTER TABLE public.agents ADD COLUMN IF NOT EXISTS agent_key_id integer REFERENCES public.agent_keys(id) ON DELETE SET NULL;
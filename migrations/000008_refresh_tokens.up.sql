@@ -0,0 +1,16 @@
+-- REFRESH_TOKENS TABLE: refresh-токены пользователей (хранится SHA-256). Токены одной цепочки
+-- обновлений объединены family_id: при повторном использовании токена отзывается вся цепочка.
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id bigserial NOT NULL,
+    user_id int4 NOT NULL,
+    family_id uuid NOT NULL,
+    token_hash char(64) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    revoked_at timestamptz,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash),
+    CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens (family_id);
@@ -0,0 +1,2 @@
+ALTER TABLE public.users DROP COLUMN IF EXISTS session_version;
+DROP TABLE IF EXISTS public.revoked_tokens;
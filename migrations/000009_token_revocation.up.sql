@@ -0,0 +1,14 @@
+-- REVOKED_TOKENS TABLE: отозванные токены доступа (по jti). Запись нужна только до истечения
+-- токена, после этого её можно удалить.
+CREATE TABLE IF NOT EXISTS public.revoked_tokens (
+    jti uuid NOT NULL,
+    user_id int4 NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti),
+    CONSTRAINT revoked_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON public.revoked_tokens (expires_at);
+
+-- Версия сессий пользователя растёт при отзыве всех его сессий: токены с меньшей версией недействительны
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS session_version integer NOT NULL DEFAULT 0;
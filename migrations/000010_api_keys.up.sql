@@ -0,0 +1,18 @@
+-- API_KEYS TABLE: личные API-ключи пользователей для скриптов и CI; хранится только SHA-256 ключа.
+-- scope: read — только чтение, submit — ещё и отправка выражений
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id serial4 NOT NULL,
+    user_id int4 NOT NULL,
+    name varchar(255) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash char(64) NOT NULL,
+    scope varchar(16) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash),
+    CONSTRAINT api_keys_scope_check CHECK (scope IN ('read', 'submit')),
+    CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON public.api_keys (user_id);
@@ -0,0 +1,5 @@
+-- Роль пользователя: user — обычный пользователь, admin — доступ к /api/v1/admin/*.
+-- Роль agent бывает только у токенов агентов и в users не хранится.
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'user';
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE public.users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'admin'));
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.failed_logins;
+DROP TABLE IF EXISTS public.login_failures;
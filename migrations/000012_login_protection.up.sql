@@ -0,0 +1,22 @@
+-- LOGIN_FAILURES TABLE: неудачные попытки входа подряд по логину (scope = 'login') и по IP (scope = 'ip').
+-- Логины учитываются и несуществующие, чтобы блокировка не выдавала, есть ли такой пользователь.
+CREATE TABLE IF NOT EXISTS public.login_failures (
+    scope varchar(8) NOT NULL,
+    key varchar(255) NOT NULL,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until timestamptz,
+    CONSTRAINT login_failures_pkey PRIMARY KEY (scope, key),
+    CONSTRAINT login_failures_scope_check CHECK (scope IN ('login', 'ip'))
+);
+
+-- FAILED_LOGINS TABLE: журнал неудачных входов для администраторов
+CREATE TABLE IF NOT EXISTS public.failed_logins (
+    id bigserial NOT NULL,
+    login varchar(255) NOT NULL,
+    ip varchar(64) NOT NULL,
+    reason varchar(32) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT failed_logins_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS failed_logins_created_at_idx ON public.failed_logins (created_at);
@@ -0,0 +1,27 @@
+-- Без организаций у выражения должен быть автор: выражения удалённых пользователей
+-- передаются владельцу организации. Если владельца нет, откат прерывается, а не удаляет их.
+UPDATE public.expressions e
+SET user_id = (
+    SELECT m.user_id FROM public.organization_members m
+    WHERE m.org_id = e.org_id AND m.role = 'owner'
+    ORDER BY m.joined_at, m.user_id LIMIT 1)
+WHERE e.user_id IS NULL AND e.org_id IS NOT NULL;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM public.expressions WHERE user_id IS NULL AND org_id IS NOT NULL) THEN
+        RAISE EXCEPTION 'organization expressions without author or owner exist, reassign them before rolling back';
+    END IF;
+END $$;
+
+-- задачи, планировавшиеся от имени организаций, переходят в долю нового автора
+UPDATE public.task_queue q SET user_id = e.user_id
+FROM public.tasks t JOIN public.expressions e ON e.id = t.expression_id
+WHERE t.id = q.task_id AND q.user_id < 0;
+DELETE FROM public.user_scheduling WHERE user_id < 0;
+
+DROP INDEX IF EXISTS public.expressions_org_id_idx;
+ALTER TABLE public.expressions DROP CONSTRAINT IF EXISTS expressions_org_id_fkey;
+ALTER TABLE public.expressions DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS public.organization_members;
+DROP TABLE IF EXISTS public.organizations;
@@ -0,0 +1,32 @@
+-- ORGANIZATIONS TABLE: команды пользователей с общей историей выражений
+CREATE TABLE IF NOT EXISTS public.organizations (
+    id serial4 NOT NULL,
+    name varchar(64) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT organizations_pkey PRIMARY KEY (id),
+    CONSTRAINT organizations_name_key UNIQUE (name)
+);
+
+-- ORGANIZATION_MEMBERS TABLE: участники и их роли в организации.
+-- viewer — только просмотр, member — ещё и отправка выражений, admin — ещё и управление
+-- участниками, owner — ещё и удаление организации и назначение владельцев
+CREATE TABLE IF NOT EXISTS public.organization_members (
+    org_id int4 NOT NULL,
+    user_id int4 NOT NULL,
+    role varchar(16) NOT NULL DEFAULT 'member',
+    joined_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT organization_members_pkey PRIMARY KEY (org_id, user_id),
+    CONSTRAINT organization_members_role_check CHECK (role IN ('owner', 'admin', 'member', 'viewer')),
+    CONSTRAINT organization_members_org_id_fkey FOREIGN KEY (org_id) REFERENCES public.organizations(id) ON DELETE CASCADE,
+    CONSTRAINT organization_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON public.organization_members (user_id);
+
+-- Выражение организации видно всем её участникам; user_id остаётся автором.
+-- При удалении организации выражения возвращаются авторам, выражения удалённых
+-- пользователей удаляются вместе с ней.
+ALTER TABLE public.expressions ADD COLUMN IF NOT EXISTS org_id int4;
+ALTER TABLE public.expressions DROP CONSTRAINT IF EXISTS expressions_org_id_fkey;
+ALTER TABLE public.expressions ADD CONSTRAINT expressions_org_id_fkey
+    FOREIGN KEY (org_id) REFERENCES public.organizations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS expressions_org_id_idx ON public.expressions (org_id);
@@ -0,0 +1,3 @@
+-- Агент, которому выдана задача. NULL — задача не в работе: ждёт в очереди, ещё не готова или уже посчитана.
+-- Результат принимается только от этого агента.
+ALTER TABLE public.tasks ADD COLUMN IF NOT EXISTS agent_id varchar(255);
@@ -0,0 +1,125 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/middleware"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// accountAPI — маршруты учётной записи, собранные как в orchestrator.StartServer
+type accountAPI struct {
+	login, me, password http.Handler
+}
+
+func newAccountAPI(t *testing.T, store *storage.PostgresStorage) accountAPI {
+	t.Helper()
+	policy, err := auth.NewPasswordPolicy(8, "")
+	require.NoError(t, err)
+	return accountAPI{
+		login:    handlers.LoginHandler(store, testProtection),
+		me:       middleware.AuthMiddleware(store, handlers.MeHandler(store, testProtection)),
+		password: middleware.AuthMiddleware(store, handlers.ChangePasswordHandler(store, policy, testProtection)),
+	}
+}
+
+// loginToken входит с логином и паролем и возвращает токен доступа
+func (a accountAPI) loginToken(t *testing.T, login, password string) string {
+	t.Helper()
+	rec := attemptLogin(a.login, login, password)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp models.LoginResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp.Token
+}
+
+func TestChangePassword(t *testing.T) {
+	store, _ := setupStorage(t)
+	createUserWithPassword(t, store, "password-user", "correct horse battery")
+	api := newAccountAPI(t, store)
+	oldToken := api.loginToken(t, "password-user", "correct horse battery")
+
+	// неверный старый пароль — 403, а не 401: токен действителен, ошибся пользователь
+	rec := testutil.Serve(api.password, http.MethodPut, "/api/v1/me/password",
+		`{"old_password":"wrong password","new_password":"new secret phrase"}`, "Authorization", "Bearer "+oldToken)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, handlers.CodeInvalidCredentials, errorCode(t, rec))
+	require.Equal(t, http.StatusOK, testutil.Serve(api.me, http.MethodGet, "/api/v1/me", "", "Authorization", "Bearer "+oldToken).Code)
+
+	rec = testutil.Serve(api.password, http.MethodPut, "/api/v1/me/password",
+		`{"old_password":"correct horse battery","new_password":"new secret phrase"}`, "Authorization", "Bearer "+oldToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var session models.LoginResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+
+	// старый токен отозван, выданный в ответе действует
+	rec = testutil.Serve(api.me, http.MethodGet, "/api/v1/me", "", "Authorization", "Bearer "+oldToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, handlers.CodeTokenRevoked, errorCode(t, rec))
+	require.Equal(t, http.StatusOK, testutil.Serve(api.me, http.MethodGet, "/api/v1/me", "", "Authorization", "Bearer "+session.Token).Code)
+
+	require.Equal(t, http.StatusUnauthorized, attemptLogin(api.login, "password-user", "correct horse battery").Code)
+	api.loginToken(t, "password-user", "new secret phrase")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createUserWithPassword(t, store, "leaving-user", "correct horse battery")
+	otherID := createTestUser(t, store, "staying-user")
+	api := newAccountAPI(t, store)
+	token := api.loginToken(t, "leaving-user", "correct horse battery")
+
+	for _, owner := range []int{userID, otherID} {
+		expr := &models.Expression{UserID: owner, Expression: "2+3*4", Status: "pending", Priority: models.DefaultPriority}
+		require.NoError(t, store.CreateExpression(ctx, expr))
+		require.NoError(t, handlers.CreateTasksFromExpression(store, expr))
+	}
+	rows := func(userID int) (expressions, tasks, queued int) {
+		require.NoError(t, testDB.QueryRow(`
+            SELECT (SELECT COUNT(*) FROM expressions WHERE user_id = $1),
+                   (SELECT COUNT(*) FROM tasks t JOIN expressions e ON e.id = t.expression_id WHERE e.user_id = $1),
+                   (SELECT COUNT(*) FROM task_queue WHERE user_id = $1)`,
+			userID).Scan(&expressions, &tasks, &queued))
+		return
+	}
+	expressions, tasks, queued := rows(userID)
+	require.Equal(t, 1, expressions)
+	require.Equal(t, 2, tasks)
+	require.Positive(t, queued)
+
+	// удаление подтверждается паролем
+	rec := testutil.Serve(api.me, http.MethodDelete, "/api/v1/me", `{"password":"wrong password"}`, "Authorization", "Bearer "+token)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	expressions, _, _ = rows(userID)
+	require.Equal(t, 1, expressions)
+
+	rec = testutil.Serve(api.me, http.MethodDelete, "/api/v1/me", `{"password":"correct horse battery"}`, "Authorization", "Bearer "+token)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	expressions, tasks, queued = rows(userID)
+	require.Zero(t, expressions)
+	require.Zero(t, tasks)
+	require.Zero(t, queued)
+	var orphanTasks int
+	require.NoError(t, testDB.QueryRow(
+		"SELECT COUNT(*) FROM tasks WHERE expression_id NOT IN (SELECT id FROM expressions)").Scan(&orphanTasks))
+	require.Zero(t, orphanTasks)
+	_, err := store.GetUserByID(ctx, userID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// данные других пользователей не затронуты, токен удалённого пользователя не действует
+	expressions, tasks, _ = rows(otherID)
+	require.Equal(t, 1, expressions)
+	require.Equal(t, 2, tasks)
+	require.Equal(t, http.StatusUnauthorized, testutil.Serve(api.me, http.MethodGet, "/api/v1/me", "", "Authorization", "Bearer "+token).Code)
+}
@@ -0,0 +1,140 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func createAgentKey(t *testing.T, store *storage.PostgresStorage, name string) int {
+	t.Helper()
+	key := &models.AgentKey{Name: name, Prefix: "ak_test", KeyHash: strings.Repeat("0", 64-len(name)) + name}
+	require.NoError(t, store.CreateAgentKey(context.Background(), key))
+	return key.ID
+}
+
+// asAgentKey кладёт в контекст ключ агента, как это делает middleware.AgentAuthMiddleware
+func asAgentKey(keyID int, next http.Handler) http.Handler {
+	return testutil.WithValue(next, "agent_key_id", keyID)
+}
+
+func TestCapabilityFilteringRestrictsDispatch(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "capability-user")
+	keyID := createAgentKey(t, store, "capability-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "mul-agent", Operations: []string{"*"}, Concurrency: 1}, keyID))
+
+	expr := &models.Expression{UserID: userID, Expression: "1+1", Status: "pending", Priority: models.DefaultPriority}
+	require.NoError(t, store.CreateExpression(ctx, expr))
+	for id, op := range map[string]string{"add-task": "+", "mul-task": "*"} {
+		require.NoError(t, store.CreateTask(ctx, &models.Task{
+			ID: id, ExpressionID: expr.ID, Arg1: "2", Arg2: "3", Operation: op,
+			Status: "pending", DependsOn: []string{}, Priority: models.DefaultPriority,
+		}))
+		require.NoError(t, store.AddTaskToQueue(ctx, id))
+	}
+
+	h := asAgentKey(keyID, handlers.GetTaskHandler(store))
+
+	// агент без идентификатора не получает задачи в обход фильтра по операциям
+	rec := testutil.Serve(h, http.MethodGet, "/internal/task", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// агенту, умеющему только умножать, выдаётся только умножение
+	rec = testutil.Serve(h, http.MethodGet, "/internal/task", "", handlers.AgentIDHeader, "mul-agent")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var task models.Task
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&task))
+	require.Equal(t, "mul-task", task.ID)
+
+	rec = testutil.Serve(h, http.MethodGet, "/internal/task", "", handlers.AgentIDHeader, "mul-agent")
+	require.Equal(t, http.StatusNotFound, rec.Code, "Addition must stay in the queue for a capable agent")
+
+	queued, err := store.GetNextTaskFromQueue(ctx, "test-agent", []string{"+"})
+	require.NoError(t, err)
+	require.NotNil(t, queued)
+	require.Equal(t, "add-task", queued.ID)
+}
+
+func TestAgentIDBoundToKey(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	ownerKey := createAgentKey(t, store, "owner-key")
+	otherKey := createAgentKey(t, store, "other-key")
+
+	register := `{"id":"bound-agent","operations":["+"],"concurrency":1}`
+	rec := testutil.Serve(asAgentKey(ownerKey, handlers.AgentsHandler(store)), http.MethodPost, "/internal/agents", register, handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// чужой ключ не может ни перерегистрировать агента, ни говорить от его имени
+	rec = testutil.Serve(asAgentKey(otherKey, handlers.AgentsHandler(store)), http.MethodPost, "/internal/agents", register, handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = testutil.Serve(asAgentKey(otherKey, handlers.AgentHeartbeatHandler(store)), http.MethodPost, "/internal/agents/bound-agent/heartbeat", "", handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = testutil.Serve(asAgentKey(otherKey, handlers.GetTaskHandler(store)), http.MethodGet, "/internal/task", "", handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	handlers.RecordAgentTaskCompleted(store, "bound-agent", otherKey)
+	handlers.RecordAgentTaskCompleted(store, "bound-agent", ownerKey)
+	agent, err := store.TouchAgent(ctx, "bound-agent", ownerKey)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, agent.TasksCompleted, "Only completions under the owner key should count")
+
+	rec = testutil.Serve(asAgentKey(ownerKey, handlers.AgentHeartbeatHandler(store)), http.MethodPost, "/internal/agents/bound-agent/heartbeat", "", handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// после отзыва ключа идентификатор можно занять новым ключом
+	_, err = store.RevokeAgentKey(ctx, ownerKey)
+	require.NoError(t, err)
+	rec = testutil.Serve(asAgentKey(otherKey, handlers.AgentsHandler(store)), http.MethodPost, "/internal/agents", register, handlers.AgentIDHeader, "bound-agent")
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestResultAcceptedOnlyFromAssignedAgent(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "assignment-user")
+	ownerKey := createAgentKey(t, store, "assigned-key")
+	otherKey := createAgentKey(t, store, "other-agent-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "assigned-agent", Operations: []string{"+"}, Concurrency: 1}, ownerKey))
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "other-agent", Operations: []string{"+"}, Concurrency: 1}, otherKey))
+	enqueueTestTask(t, store, testDB, userID, "assigned-task", models.DefaultPriority, 0)
+
+	task, err := store.GetNextTaskFromQueue(ctx, "assigned-agent", nil)
+	require.NoError(t, err)
+	require.Equal(t, "assigned-task", task.ID)
+
+	submit := func(keyID int, agentID, body string) int {
+		return testutil.Serve(asAgentKey(keyID, handlers.RequeueTaskHandler(store)),
+			http.MethodPost, "/internal/task/requeue", body, handlers.AgentIDHeader, agentID).Code
+	}
+	completed := `{"id":"assigned-task","status":"completed","result":2}`
+
+	// другой агент не может ни сдать результат, ни вернуть задачу в очередь, ни провалить её
+	require.Equal(t, http.StatusConflict, submit(otherKey, "other-agent", completed))
+	require.Equal(t, http.StatusConflict, submit(otherKey, "other-agent", `{"id":"assigned-task","status":"pending"}`))
+	require.Equal(t, http.StatusConflict, submit(otherKey, "other-agent", `{"id":"assigned-task","status":"failed"}`))
+	require.Empty(t, queuedTaskIDs(t, testDB))
+
+	require.Equal(t, http.StatusOK, submit(ownerKey, "assigned-agent", completed))
+	stored, err := store.GetTaskByID(ctx, "assigned-task")
+	require.NoError(t, err)
+	require.Equal(t, "completed", stored.Status)
+
+	// посчитанная задача уже не в работе: повторный результат отклоняется
+	require.Equal(t, http.StatusConflict, submit(ownerKey, "assigned-agent", `{"id":"assigned-task","status":"completed","result":3}`))
+	stored, err = store.GetTaskByID(ctx, "assigned-task")
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *stored.Result)
+}
@@ -0,0 +1,212 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/middleware"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// issueTestToken выдаёт пользователю токен доступа с версией сессий sessionVersion
+func issueTestToken(t *testing.T, userID, sessionVersion int) *auth.Claims {
+	t.Helper()
+	token, err := auth.GenerateToken(userID, auth.RoleUser, sessionVersion)
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(token)
+	require.NoError(t, err)
+	return claims
+}
+
+func tokenRevoked(t *testing.T, store *storage.PostgresStorage, claims *auth.Claims) bool {
+	t.Helper()
+	revoked, err := store.TokenRevoked(context.Background(), claims.ID, claims.UserID, claims.SessionVersion, claims.ExpiresAt.Time)
+	require.NoError(t, err)
+	return revoked
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "refresh-user")
+	expiresAt := time.Now().Add(time.Hour)
+
+	require.NoError(t, store.CreateRefreshToken(ctx, userID, uuid.NewString(), "hash-1", expiresAt))
+
+	// каждый токен погашается и заменяется следующим в той же цепочке
+	owner, err := store.RotateRefreshToken(ctx, "hash-1", "hash-2", expiresAt)
+	require.NoError(t, err)
+	require.Equal(t, userID, owner)
+	_, err = store.RotateRefreshToken(ctx, "hash-2", "hash-3", expiresAt)
+	require.NoError(t, err)
+
+	// повторное предъявление погашенного токена отзывает всю цепочку, включая последний токен
+	_, err = store.RotateRefreshToken(ctx, "hash-1", "hash-stolen", expiresAt)
+	require.ErrorIs(t, err, storage.ErrRefreshTokenReused)
+	_, err = store.RotateRefreshToken(ctx, "hash-3", "hash-4", expiresAt)
+	require.ErrorIs(t, err, sql.ErrNoRows, "Newest token of a reused family must be revoked")
+
+	// другие цепочки пользователя не затрагиваются
+	require.NoError(t, store.CreateRefreshToken(ctx, userID, uuid.NewString(), "other-1", expiresAt))
+	_, err = store.RotateRefreshToken(ctx, "other-1", "other-2", expiresAt)
+	require.NoError(t, err)
+
+	_, err = store.RotateRefreshToken(ctx, "unknown", "hash-5", expiresAt)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	require.NoError(t, store.CreateRefreshToken(ctx, userID, uuid.NewString(), "expired-1", time.Now().Add(-time.Minute)))
+	_, err = store.RotateRefreshToken(ctx, "expired-1", "expired-2", expiresAt)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestLogoutRevokesTokenAndRefreshFamily(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "logout-user")
+	session := issueTestToken(t, userID, 0)
+	other := issueTestToken(t, userID, 0)
+
+	refreshToken := "refresh-logout-user"
+	require.NoError(t, store.CreateRefreshToken(ctx, userID, uuid.NewString(), auth.HashAPIKey(refreshToken), time.Now().Add(time.Hour)))
+
+	// ответ «не отозван» попадает в кэш, выход должен его перекрыть
+	require.False(t, tokenRevoked(t, store, session))
+
+	rec := testutil.Serve(testutil.WithValue(handlers.LogoutHandler(store), "claims", session),
+		http.MethodPost, "/api/v1/logout", `{"refresh_token":"`+refreshToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.True(t, tokenRevoked(t, store, session), "Logged out token must be rejected at once on this replica")
+	require.False(t, tokenRevoked(t, store, other), "Other sessions stay valid after logout")
+	_, err := store.RotateRefreshToken(ctx, auth.HashAPIKey(refreshToken), "after-logout", time.Now().Add(time.Hour))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// другая реплика с пустым кэшем узнаёт об отзыве из БД
+	replica, err := storage.NewPostgresStorage(testConnStr())
+	require.NoError(t, err)
+	defer replica.Close()
+	require.True(t, tokenRevoked(t, replica, session))
+	require.False(t, tokenRevoked(t, replica, other))
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "sessions-user")
+	bystander := createTestUser(t, store, "bystander-user")
+	first := issueTestToken(t, userID, 0)
+	second := issueTestToken(t, userID, 0)
+	unrelated := issueTestToken(t, bystander, 0)
+	require.NoError(t, store.CreateRefreshToken(ctx, userID, uuid.NewString(), "sessions-refresh", time.Now().Add(time.Hour)))
+
+	require.False(t, tokenRevoked(t, store, first))
+	require.NoError(t, store.RevokeUserSessions(ctx, userID))
+
+	// закэшированный ответ сбрасывается сразу, а не через revocationCacheTTL
+	require.True(t, tokenRevoked(t, store, first))
+	require.True(t, tokenRevoked(t, store, second))
+	require.False(t, tokenRevoked(t, store, unrelated))
+	_, err := store.RotateRefreshToken(ctx, "sessions-refresh", "sessions-refresh-2", time.Now().Add(time.Hour))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// токен, выданный сразу после отзыва (в ту же секунду), действителен: сравнивается версия, а не время
+	user, err := store.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, 1, user.SessionVersion)
+	fresh := issueTestToken(t, userID, user.SessionVersion)
+	require.False(t, tokenRevoked(t, store, fresh))
+
+	require.ErrorIs(t, store.RevokeUserSessions(ctx, 1<<30), sql.ErrNoRows)
+}
+
+// createTestAPIKey выпускает пользователю личный API-ключ с правами scope
+func createTestAPIKey(t *testing.T, store *storage.PostgresStorage, userID int, scope string) string {
+	t.Helper()
+	key, err := auth.NewAPIKey(auth.UserKeyPrefix)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAPIKey(context.Background(), &models.APIKey{
+		UserID: userID, Name: scope + "-key", Prefix: auth.APIKeyDisplayPrefix(key),
+		KeyHash: auth.HashAPIKey(key), Scope: scope,
+	}))
+	return key
+}
+
+func TestAPIKeyScopesAndRevocation(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "apikey-user")
+	calculate := middleware.AuthMiddleware(store, handlers.ExpressionHandler(store, models.SubmissionLimits{}))
+	list := middleware.AuthMiddleware(store, handlers.GetExpressionsHandler(store))
+
+	// ключ read только читает, submit ещё и отправляет выражения
+	readKey := createTestAPIKey(t, store, userID, auth.ScopeRead)
+	submitKey := createTestAPIKey(t, store, userID, auth.ScopeSubmit)
+	require.Equal(t, http.StatusForbidden, testutil.Serve(calculate, http.MethodPost, "/api/v1/calculate", `{"expression":"2+2"}`, "Authorization", "ApiKey "+readKey).Code)
+	require.Equal(t, http.StatusOK, testutil.Serve(list, http.MethodGet, "/api/v1/expressions", "", "Authorization", "ApiKey "+readKey).Code)
+	require.Equal(t, http.StatusCreated, testutil.Serve(calculate, http.MethodPost, "/api/v1/calculate", `{"expression":"2+2"}`, "Authorization", "ApiKey "+submitKey).Code)
+
+	// смена роли отзывает токены, но не ключи: они всегда действуют с ролью user
+	require.NoError(t, store.SetUserRole(ctx, userID, auth.RoleAdmin))
+	require.Equal(t, http.StatusOK, testutil.Serve(list, http.MethodGet, "/api/v1/expressions", "", "Authorization", "ApiKey "+readKey).Code)
+
+	// отзыв всех сессий отзывает и ключи
+	require.NoError(t, store.RevokeUserSessions(ctx, userID))
+	require.Equal(t, http.StatusUnauthorized, testutil.Serve(list, http.MethodGet, "/api/v1/expressions", "", "Authorization", "ApiKey "+readKey).Code)
+	require.Equal(t, http.StatusUnauthorized, testutil.Serve(calculate, http.MethodPost, "/api/v1/calculate", `{"expression":"2+2"}`, "Authorization", "ApiKey "+submitKey).Code)
+
+	// как и смена пароля
+	afterRevoke := createTestAPIKey(t, store, userID, auth.ScopeRead)
+	require.Equal(t, http.StatusOK, testutil.Serve(list, http.MethodGet, "/api/v1/expressions", "", "Authorization", "ApiKey "+afterRevoke).Code)
+	require.NoError(t, store.SetPassword(ctx, userID, "new-hash"))
+	require.Equal(t, http.StatusUnauthorized, testutil.Serve(list, http.MethodGet, "/api/v1/expressions", "", "Authorization", "ApiKey "+afterRevoke).Code)
+}
+
+func TestAdminMiddlewareByRole(t *testing.T) {
+	store, _ := setupStorage(t)
+	userID := createTestUser(t, store, "plain-user")
+	adminID := createTestUser(t, store, "admin-user")
+	require.NoError(t, store.SetUserRole(context.Background(), adminID, auth.RoleAdmin))
+
+	h := middleware.AdminMiddleware("", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(userID, sessionVersion int, role string) int {
+		token, err := auth.GenerateToken(userID, role, sessionVersion)
+		require.NoError(t, err)
+		return testutil.Serve(h, http.MethodGet, "/api/v1/admin/users", "", "Authorization", "Bearer "+token).Code
+	}
+
+	require.Equal(t, http.StatusForbidden, request(userID, 0, auth.RoleUser))
+	// SetUserRole отозвал прежние сессии, новая роль действует в токене с новой версией
+	require.Equal(t, http.StatusUnauthorized, request(adminID, 0, auth.RoleAdmin))
+	require.Equal(t, http.StatusOK, request(adminID, 1, auth.RoleAdmin))
+}
+
+func TestRegisterLoginTaken(t *testing.T) {
+	store, _ := setupStorage(t)
+	policy, err := auth.NewPasswordPolicy(8, "")
+	require.NoError(t, err)
+	h := handlers.RegisterHandler(store, policy)
+
+	register := func() *httptest.ResponseRecorder {
+		return testutil.Serve(h, http.MethodPost, "/api/v1/register", `{"login":"taken-user","password":"correct horse battery"}`)
+	}
+
+	require.Equal(t, http.StatusOK, register().Code)
+	rec := register()
+	require.Equal(t, http.StatusConflict, rec.Code)
+	var resp handlers.ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Equal(t, handlers.CodeLoginTaken, resp.Code)
+}
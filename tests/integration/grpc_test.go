@@ -0,0 +1,158 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agentpb"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/grpcserver"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCServer запускает настоящий grpcserver поверх bufconn и возвращает клиента к нему
+func startGRPCServer(t *testing.T, store *storage.PostgresStorage) agentpb.AgentServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	srv := grpcserver.NewServer(store)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return agentpb.NewAgentServiceClient(conn)
+}
+
+// agentContext добавляет к запросу метаданные, которые отправляет агент; пустые значения пропускаются
+func agentContext(ctx context.Context, token, agentID string) context.Context {
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	if agentID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-agent-id", agentID)
+	}
+	return ctx
+}
+
+func requireCode(t *testing.T, want codes.Code, err error) {
+	t.Helper()
+	require.Equal(t, want, status.Code(err), "Unexpected error: %v", err)
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "grpc-user")
+	keyID := createAgentKey(t, store, "grpc-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "grpc-agent", Operations: []string{"+"}, Concurrency: 1}, keyID))
+	enqueueTestTask(t, store, testDB, userID, "grpc-known", models.DefaultPriority, 0)
+	client := startGRPCServer(t, store)
+
+	agentToken, err := auth.GenerateAgentToken(keyID)
+	require.NoError(t, err)
+	userToken, err := auth.GenerateToken(userID, auth.RoleUser, 0)
+	require.NoError(t, err)
+
+	_, err = client.Heartbeat(agentContext(ctx, "", "grpc-agent"), &agentpb.HeartbeatRequest{})
+	requireCode(t, codes.Unauthenticated, err)
+	_, err = client.Heartbeat(agentContext(ctx, "invalid.token.here", "grpc-agent"), &agentpb.HeartbeatRequest{})
+	requireCode(t, codes.Unauthenticated, err)
+	_, err = client.Heartbeat(agentContext(ctx, userToken, "grpc-agent"), &agentpb.HeartbeatRequest{})
+	requireCode(t, codes.PermissionDenied, err)
+	_, err = client.Heartbeat(agentContext(ctx, agentToken, ""), &agentpb.HeartbeatRequest{})
+	requireCode(t, codes.InvalidArgument, err)
+
+	_, err = client.Heartbeat(agentContext(ctx, agentToken, "grpc-agent"), &agentpb.HeartbeatRequest{})
+	require.NoError(t, err)
+
+	// задачу по идентификатору получает только зарегистрированный агент, как и в HTTP
+	_, err = client.GetTask(agentContext(ctx, agentToken, "stranger"), &agentpb.GetTaskRequest{TaskId: "grpc-known"})
+	requireCode(t, codes.FailedPrecondition, err)
+	resp, err := client.GetTask(agentContext(ctx, agentToken, "grpc-agent"), &agentpb.GetTaskRequest{TaskId: "grpc-known"})
+	require.NoError(t, err)
+	require.Equal(t, "grpc-known", resp.Task.Id)
+
+	// после отзыва ключа его токены не принимают ни unary-вызовы, ни стримы
+	_, err = store.RevokeAgentKey(ctx, keyID)
+	require.NoError(t, err)
+	_, err = client.Heartbeat(agentContext(ctx, agentToken, "grpc-agent"), &agentpb.HeartbeatRequest{})
+	requireCode(t, codes.Unauthenticated, err)
+
+	stream, err := client.StreamTasks(agentContext(ctx, agentToken, "grpc-agent"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&agentpb.AgentMessage{Payload: &agentpb.AgentMessage_Hello{Hello: &agentpb.Hello{Concurrency: 1}}}))
+	_, err = stream.Recv()
+	requireCode(t, codes.Unauthenticated, err)
+}
+
+func TestGRPCStreamRequeuesOnCancel(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "grpc-stream-user")
+	keyID := createAgentKey(t, store, "grpc-stream-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "grpc-stream-agent", Operations: []string{"+"}, Concurrency: 1}, keyID))
+	enqueueTestTask(t, store, testDB, userID, "grpc-a", models.DefaultPriority, time.Minute)
+	enqueueTestTask(t, store, testDB, userID, "grpc-b", models.DefaultPriority, 0)
+	client := startGRPCServer(t, store)
+
+	agentToken, err := auth.GenerateAgentToken(keyID)
+	require.NoError(t, err)
+	streamCtx, cancel := context.WithCancel(agentContext(ctx, agentToken, "grpc-stream-agent"))
+	defer cancel()
+	stream, err := client.StreamTasks(streamCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&agentpb.AgentMessage{Payload: &agentpb.AgentMessage_Hello{Hello: &agentpb.Hello{Concurrency: 1}}}))
+
+	assignment, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "grpc-a", assignment.Task.Id)
+	// concurrency 1: вторая задача остаётся в очереди
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, []string{"grpc-b"}, queuedTaskIDs(t, testDB))
+
+	// агент отменил стрим, не ответив: выданная задача возвращается в очередь
+	cancel()
+	require.Eventually(t, func() bool { return len(queuedTaskIDs(t, testDB)) == 2 },
+		5*time.Second, 50*time.Millisecond, "In-flight task should be requeued after the client cancels")
+}
+
+func TestGRPCSubmitResultRequiresAssignment(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "grpc-result-user")
+	keyID := createAgentKey(t, store, "grpc-result-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "grpc-owner", Operations: []string{"+"}, Concurrency: 1}, keyID))
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "grpc-other", Operations: []string{"+"}, Concurrency: 1}, keyID))
+	enqueueTestTask(t, store, testDB, userID, "grpc-assigned", models.DefaultPriority, 0)
+	client := startGRPCServer(t, store)
+
+	agentToken, err := auth.GenerateAgentToken(keyID)
+	require.NoError(t, err)
+	resp, err := client.GetTask(agentContext(ctx, agentToken, "grpc-owner"), &agentpb.GetTaskRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "grpc-assigned", resp.Task.Id)
+
+	result := 2.0
+	submit := &agentpb.SubmitResultRequest{TaskId: "grpc-assigned", Status: "completed", Result: &result}
+	_, err = client.SubmitResult(agentContext(ctx, agentToken, "grpc-other"), submit)
+	requireCode(t, codes.Aborted, err)
+	_, err = client.SubmitResult(agentContext(ctx, agentToken, "grpc-owner"), submit)
+	require.NoError(t, err)
+	_, err = client.SubmitResult(agentContext(ctx, agentToken, "grpc-owner"), submit)
+	requireCode(t, codes.Aborted, err)
+}
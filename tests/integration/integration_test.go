@@ -2,6 +2,7 @@ package integration
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,9 @@ import (
 	"time"
 
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agent"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/config"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/orchestrator"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
 	"github.com/lib/pq"
@@ -44,17 +48,14 @@ func teardown() {
 }
 
 func TestIntegration(t *testing.T) {
-	connStr := "user=postgres dbname=test_calculator_db password=Ebds777staX sslmode=disable"
-	if envConnStr := os.Getenv("TEST_DB_CONN_STR"); envConnStr != "" {
-		connStr = envConnStr
-	}
+	connStr := testConnStr()
 
 	var err error
 	db, err = sql.Open("postgres", connStr)
 	require.NoError(t, err)
 	defer db.Close()
 
-	err = initDatabaseSchema(db)
+	err = migrateDatabase(connStr)
 	require.NoError(t, err)
 
 	err = clearDatabase(db)
@@ -64,7 +65,10 @@ func TestIntegration(t *testing.T) {
 	require.NoError(t, err)
 	defer store.Close()
 
-	orchestratorServer := orchestrator.StartServer(connStr)
+	cfg := config.DefaultOrchestrator()
+	cfg.Database.DSN = connStr
+	cfg.JWTSecret = "integration-test-secret"
+	orchestratorServer := orchestrator.StartServer(&cfg)
 	defer orchestrator.ShutdownServer(orchestratorServer)
 
 	time.Sleep(2 * time.Second)
@@ -75,7 +79,10 @@ func TestIntegration(t *testing.T) {
 	token, err := loginUser()
 	require.NoError(t, err)
 
-	ag, err := agent.NewAgent(testUser, testPassword, "http://localhost:8080")
+	agentKey, err := issueAgentKey(store)
+	require.NoError(t, err)
+
+	ag, err := agent.NewAgent(agentKey, "http://localhost:8080")
 	require.NoError(t, err)
 	require.NoError(t, ag.Start())
 	defer ag.Stop()
@@ -115,47 +122,77 @@ func TestIntegration(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
 	})
+
+	t.Run("Rejected submissions do not count towards the rate limit", func(t *testing.T) {
+		var userID int
+		require.NoError(t, db.QueryRow("SELECT id FROM users WHERE login = $1", testUser).Scan(&userID))
+		perMinute, maxLength := 2, 10
+		require.NoError(t, store.SetLimitOverrides(context.Background(), userID, &models.LimitOverrides{
+			SubmissionsPerMinute: &perMinute,
+			MaxExpressionLength:  &maxLength,
+		}))
+		_, err := db.Exec("DELETE FROM submission_counters WHERE user_id = $1", userID)
+		require.NoError(t, err)
+		defer store.SetLimitOverrides(context.Background(), userID, &models.LimitOverrides{})
+
+		for i := 0; i < 3; i++ {
+			resp, err := submitExpressionWithError(token, "1+2+3+4+5+6")
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+			resp, err = submitExpressionWithError(token, "2++3")
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err := submitExpression(token, "1+1")
+			require.NoError(t, err, "Rejected submissions should not use up the per-minute limit")
+		}
+		resp, err := submitExpressionWithError(token, "1+1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	})
+
+	t.Run("Division by zero at runtime fails the expression", func(t *testing.T) {
+		exprID, err := submitExpression(token, "5/(2-2)")
+		require.NoError(t, err)
+
+		time.Sleep(5 * time.Second)
+
+		var status string
+		err = db.QueryRow("SELECT status FROM expressions WHERE id = $1", exprID).Scan(&status)
+		require.NoError(t, err)
+		assert.Equal(t, "failed", status)
+
+		var queued int
+		err = db.QueryRow(`SELECT COUNT(*) FROM task_queue q JOIN tasks t ON t.id = q.task_id
+			WHERE t.expression_id = $1`, exprID).Scan(&queued)
+		require.NoError(t, err)
+		assert.Zero(t, queued, "Failed task should not be requeued")
+	})
 }
 
-func initDatabaseSchema(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			login TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE TABLE IF NOT EXISTS expressions (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER REFERENCES users(id),
-			expression TEXT NOT NULL,
-			result FLOAT,
-			status TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE TABLE IF NOT EXISTS tasks (
-			id TEXT PRIMARY KEY,
-			expression_id INTEGER REFERENCES expressions(id),
-			arg1 TEXT NOT NULL,
-			arg2 TEXT NOT NULL,
-			operation TEXT NOT NULL,
-			operation_time INTEGER NOT NULL,
-			status TEXT NOT NULL,
-			result FLOAT,
-			depends_on TEXT[]
-		);
-		
-		CREATE TABLE IF NOT EXISTS task_queue (
-			task_id TEXT PRIMARY KEY REFERENCES tasks(id)
-		);
-	`)
-	return err
+// issueAgentKey выдаёт ключ агенту так же, как POST /api/v1/admin/agent-keys
+func issueAgentKey(store *storage.PostgresStorage) (string, error) {
+	key, err := auth.NewAPIKey(auth.AgentKeyPrefix)
+	if err != nil {
+		return "", err
+	}
+	agentKey := models.AgentKey{
+		Name:    "integration-test",
+		Prefix:  auth.APIKeyDisplayPrefix(key),
+		KeyHash: auth.HashAPIKey(key),
+	}
+	return key, store.CreateAgentKey(context.Background(), &agentKey)
 }
 
 func clearDatabase(db *sql.DB) error {
-	tables := []string{"task_queue", "tasks", "expressions", "users"}
+	tables := []string{"login_failures", "failed_logins", "api_keys", "revoked_tokens", "refresh_tokens", "agent_keys", "agents", "operation_times", "submission_counters", "user_limits", "user_scheduling", "task_queue", "tasks", "expressions", "organization_members", "organizations", "users"}
 	for _, table := range tables {
 		_, err := db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE", pq.QuoteIdentifier(table)))
 		if err != nil {
@@ -0,0 +1,142 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testProtection = models.LoginProtection{
+	FreeAttempts:   2,
+	IPFreeAttempts: 100,
+	BaseDelay:      time.Minute,
+	MaxDelay:       time.Hour,
+	ResetAfter:     time.Hour,
+}
+
+// createUserWithPassword создаёт пользователя, который может войти с паролем password
+func createUserWithPassword(t *testing.T, store *storage.PostgresStorage, login, password string) int {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &models.User{Login: login, PasswordHash: string(hash)}
+	require.NoError(t, store.CreateUser(context.Background(), user))
+	return user.ID
+}
+
+func attemptLogin(h http.Handler, login, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.LoginRequest{Login: login, Password: password})
+	return testutil.Serve(h, http.MethodPost, "/api/v1/login", string(body))
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp handlers.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp.Code
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	store, _ := setupStorage(t)
+	createUserWithPassword(t, store, "locked-user", "correct horse battery")
+	login := handlers.LoginHandler(store, testProtection)
+
+	// первые FreeAttempts ошибок и ещё одна проходят, после неё логин заблокирован
+	for i := 0; i <= testProtection.FreeAttempts; i++ {
+		rec := attemptLogin(login, "locked-user", "wrong password")
+		require.Equal(t, http.StatusUnauthorized, rec.Code, "Attempt %d", i+1)
+		require.Equal(t, handlers.CodeInvalidCredentials, errorCode(t, rec))
+	}
+	rec := attemptLogin(login, "locked-user", "correct horse battery")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code, "Even the right password is refused while locked")
+	require.Equal(t, handlers.CodeLoginLocked, errorCode(t, rec))
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	lockouts, err := store.ListLoginLockouts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+	require.Equal(t, storage.LoginScope, lockouts[0].Scope)
+	require.Equal(t, "locked-user", lockouts[0].Key)
+
+	// администратор снимает блокировку досрочно
+	unlock := handlers.LoginLockoutsHandler(store)
+	rec = testutil.Serve(unlock, http.MethodDelete, "/api/v1/admin/login-lockouts?login=locked-user", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = attemptLogin(login, "locked-user", "correct horse battery")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// успешный вход сбросил счётчик, снимать больше нечего
+	rec = testutil.Serve(unlock, http.MethodDelete, "/api/v1/admin/login-lockouts?login=locked-user", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLoginResponsesDoNotRevealUsers(t *testing.T) {
+	store, _ := setupStorage(t)
+	createUserWithPassword(t, store, "existing-user", "correct horse battery")
+	login := handlers.LoginHandler(store, testProtection)
+
+	// несуществующий логин и неверный пароль неотличимы ни по ответу, ни по блокировке
+	for i := 0; i <= testProtection.FreeAttempts+1; i++ {
+		unknown := attemptLogin(login, "ghost-user", "wrong password")
+		wrong := attemptLogin(login, "existing-user", "wrong password")
+		require.Equal(t, wrong.Code, unknown.Code, "Attempt %d", i+1)
+		require.Equal(t, wrong.Body.String(), unknown.Body.String(), "Attempt %d", i+1)
+		require.Equal(t, wrong.Header().Get("Retry-After") == "", unknown.Header().Get("Retry-After") == "", "Attempt %d", i+1)
+	}
+
+	failed, err := store.ListFailedLogins(context.Background(), 100)
+	require.NoError(t, err)
+	reasons := map[string]int{}
+	for _, f := range failed {
+		reasons[f.Reason]++
+	}
+	require.Positive(t, reasons["unknown_login"])
+	require.Positive(t, reasons["wrong_password"])
+	require.Positive(t, reasons["locked"])
+}
+
+func TestPruneLoginHistory(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+
+	_, err := testDB.Exec(`
+        INSERT INTO login_failures (scope, key, failures, last_failure_at) VALUES
+            ('login', 'stale', 3, CURRENT_TIMESTAMP - INTERVAL '2 hours'),
+            ('login', 'recent', 3, CURRENT_TIMESTAMP - INTERVAL '10 minutes')`)
+	require.NoError(t, err)
+	_, err = testDB.Exec(`
+        INSERT INTO failed_logins (login, ip, reason, created_at) VALUES
+            ('stale', '192.0.2.1', 'wrong_password', CURRENT_TIMESTAMP - INTERVAL '31 days'),
+            ('recent', '192.0.2.1', 'wrong_password', CURRENT_TIMESTAMP - INTERVAL '1 day')`)
+	require.NoError(t, err)
+
+	require.NoError(t, store.PruneLoginHistory(ctx, testProtection))
+
+	var keys []string
+	rows, err := testDB.Query("SELECT key FROM login_failures ORDER BY key")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		require.NoError(t, rows.Scan(&key))
+		keys = append(keys, key)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"recent"}, keys)
+
+	failed, err := store.ListFailedLogins(ctx, 100)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Equal(t, "recent", failed[0].Login)
+}
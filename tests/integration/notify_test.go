@@ -0,0 +1,126 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// Ожидающий должен проснуться намного раньше, чем истечёт его long-poll
+const (
+	notifyWait   = 20 * time.Second
+	notifyPrompt = 3 * time.Second
+)
+
+// listeningReplica запускает вторую реплику оркестратора со своим слушателем NOTIFY.
+// Соединения реплики помечены application_name, чтобы тест мог найти их в pg_stat_activity.
+func listeningReplica(t *testing.T, name string) *storage.PostgresStorage {
+	t.Helper()
+	replica, err := storage.NewPostgresStorage(testConnStr() + " application_name=" + name)
+	require.NoError(t, err)
+	t.Cleanup(func() { replica.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	require.NoError(t, replica.ListenTaskQueue(ctx))
+	return replica
+}
+
+// createPendingTask создаёт выражение из одной задачи, не ставя задачу в очередь
+func createPendingTask(t *testing.T, store *storage.PostgresStorage, userID int, id string) {
+	t.Helper()
+	ctx := context.Background()
+	expr := &models.Expression{UserID: userID, Expression: "1+1", Status: "pending", Priority: models.DefaultPriority}
+	require.NoError(t, store.CreateExpression(ctx, expr))
+	require.NoError(t, store.CreateTask(ctx, &models.Task{
+		ID: id, ExpressionID: expr.ID, Arg1: "1", Arg2: "1", Operation: "+",
+		Status: "pending", DependsOn: []string{}, Priority: models.DefaultPriority,
+	}))
+}
+
+// waitForTask ждёт задачу на реплике в фоне; в канал приходит идентификатор полученной задачи
+func waitForTask(t *testing.T, replica *storage.PostgresStorage) <-chan string {
+	got := make(chan string, 1)
+	go func() {
+		task, err := replica.WaitNextTaskFromQueue(context.Background(), notifyWait, "test-agent", nil)
+		if err != nil {
+			t.Errorf("Wait for task failed: %v", err)
+		}
+		if task == nil {
+			got <- ""
+			return
+		}
+		got <- task.ID
+	}()
+	// ожидающий успевает проверить пустую очередь и уснуть
+	time.Sleep(200 * time.Millisecond)
+	return got
+}
+
+func requireWokenWith(t *testing.T, got <-chan string, taskID string) {
+	t.Helper()
+	select {
+	case id := <-got:
+		require.Equal(t, taskID, id)
+	case <-time.After(notifyPrompt):
+		t.Fatalf("Waiter was not woken within %s after task %s was queued on another replica", notifyPrompt, taskID)
+	}
+}
+
+// listenerBackends возвращает число соединений реплики name, слушающих канал очереди
+func listenerBackends(t *testing.T, testDB *sql.DB, name string) int {
+	t.Helper()
+	var n int
+	require.NoError(t, testDB.QueryRow(`
+        SELECT COUNT(*) FROM pg_stat_activity
+        WHERE application_name = $1 AND query LIKE 'LISTEN %'`,
+		name).Scan(&n))
+	return n
+}
+
+func TestNotifyWakesWaiterOnOtherReplica(t *testing.T) {
+	store, _ := setupStorage(t)
+	userID := createTestUser(t, store, "notify-user")
+	replica := listeningReplica(t, "notify-replica")
+
+	createPendingTask(t, store, userID, "notify-task")
+	got := waitForTask(t, replica)
+	require.NoError(t, store.AddTaskToQueue(context.Background(), "notify-task"))
+	requireWokenWith(t, got, "notify-task")
+}
+
+func TestNotifyListenerReconnects(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "reconnect-user")
+	replica := listeningReplica(t, "reconnect-replica")
+	require.Eventually(t, func() bool { return listenerBackends(t, testDB, "reconnect-replica") == 1 },
+		5*time.Second, 50*time.Millisecond)
+
+	// обрываем соединение слушателя; задача, поставленная в очередь, пока его нет,
+	// не должна потеряться: после переподключения ожидающие проверяют очередь заново
+	createPendingTask(t, store, userID, "outage-task")
+	got := waitForTask(t, replica)
+	var terminated int
+	require.NoError(t, testDB.QueryRow(`
+        SELECT COUNT(*) FROM (
+            SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+            WHERE application_name = $1 AND query LIKE 'LISTEN %') killed`,
+		"reconnect-replica").Scan(&terminated))
+	require.Equal(t, 1, terminated)
+	require.NoError(t, store.AddTaskToQueue(ctx, "outage-task"))
+	requireWokenWith(t, got, "outage-task")
+
+	// после переподключения уведомления снова доходят
+	require.Eventually(t, func() bool { return listenerBackends(t, testDB, "reconnect-replica") == 1 },
+		5*time.Second, 50*time.Millisecond)
+	createPendingTask(t, store, userID, "after-reconnect-task")
+	got = waitForTask(t, replica)
+	require.NoError(t, store.AddTaskToQueue(ctx, "after-reconnect-task"))
+	requireWokenWith(t, got, "after-reconnect-task")
+}
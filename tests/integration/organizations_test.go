@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// asUser кладёт в контекст пользователя, как это делает middleware.AuthMiddleware
+func asUser(userID int, next http.Handler) http.Handler {
+	return testutil.WithValue(testutil.WithValue(next, "role", auth.RoleUser), "user_id", userID)
+}
+
+func createTestOrg(t *testing.T, store *storage.PostgresStorage, name string, ownerID int) int {
+	t.Helper()
+	org := &models.Organization{Name: name}
+	require.NoError(t, store.CreateOrganization(context.Background(), org, ownerID))
+	return org.ID
+}
+
+// createOrgExpression создаёт выражение организации orgID (nil — личное) с одной задачей в очереди
+func createOrgExpression(t *testing.T, store *storage.PostgresStorage, userID int, orgID *int, taskID string) int {
+	t.Helper()
+	ctx := context.Background()
+	expr := &models.Expression{UserID: userID, Expression: "1+1", Status: "pending", Priority: models.DefaultPriority, OrgID: orgID}
+	require.NoError(t, store.CreateExpression(ctx, expr))
+	require.NoError(t, store.CreateTask(ctx, &models.Task{
+		ID: taskID, ExpressionID: expr.ID, Arg1: "1", Arg2: "1", Operation: "+",
+		Status: "pending", DependsOn: []string{}, Priority: models.DefaultPriority,
+	}))
+	require.NoError(t, store.AddTaskToQueue(ctx, taskID))
+	return expr.ID
+}
+
+// expressionRows возвращает число строк выражения, его задач и задач в очереди
+func expressionRows(t *testing.T, testDB *sql.DB, exprID int) (expressions, tasks, queued int) {
+	t.Helper()
+	require.NoError(t, testDB.QueryRow(`
+        SELECT (SELECT COUNT(*) FROM expressions WHERE id = $1),
+               (SELECT COUNT(*) FROM tasks WHERE expression_id = $1),
+               (SELECT COUNT(*) FROM task_queue q JOIN tasks t ON t.id = q.task_id WHERE t.expression_id = $1)`,
+		exprID).Scan(&expressions, &tasks, &queued))
+	return expressions, tasks, queued
+}
+
+func TestOrgExpressionAccess(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	owner := createTestUser(t, store, "org-owner")
+	viewer := createTestUser(t, store, "org-viewer")
+	outsider := createTestUser(t, store, "org-outsider")
+	orgID := createTestOrg(t, store, "access-org", owner)
+	_, err := store.AddMember(ctx, orgID, "org-viewer", models.OrgRoleViewer)
+	require.NoError(t, err)
+
+	submit := handlers.ExpressionHandler(store, models.SubmissionLimits{})
+	body := fmt.Sprintf(`{"expression":"2+2","org_id":%d}`, orgID)
+
+	// viewer только смотрит, посторонний не может отправлять в чужую организацию
+	require.Equal(t, http.StatusForbidden, testutil.Serve(asUser(viewer, submit), http.MethodPost, "/api/v1/calculate", body).Code)
+	require.Equal(t, http.StatusForbidden, testutil.Serve(asUser(outsider, submit), http.MethodPost, "/api/v1/calculate", body).Code)
+
+	rec := testutil.Serve(asUser(owner, submit), http.MethodPost, "/api/v1/calculate", body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created map[string]int
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// выражения организации видны её участникам, посторонним организация отвечает 404
+	list := handlers.GetExpressionsHandler(store)
+	target := fmt.Sprintf("/api/v1/expressions?org_id=%d", orgID)
+	rec = testutil.Serve(asUser(viewer, list), http.MethodGet, target, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var exprs []models.Expression
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &exprs))
+	require.Len(t, exprs, 1)
+	require.Equal(t, created["id"], exprs[0].ID)
+	require.Equal(t, http.StatusNotFound, testutil.Serve(asUser(outsider, list), http.MethodGet, target, "").Code)
+
+	orgExpr, err := store.GetExpressionByID(ctx, created["id"])
+	require.NoError(t, err)
+	for userID, want := range map[int]bool{owner: true, viewer: true, outsider: false} {
+		ok, err := store.CanViewExpression(ctx, userID, orgExpr)
+		require.NoError(t, err)
+		require.Equal(t, want, ok, "User %d", userID)
+	}
+
+	// личные выражения участнику организации не видны
+	personal := &models.Expression{UserID: owner, Expression: "3+3", Status: "pending", Priority: models.DefaultPriority}
+	require.NoError(t, store.CreateExpression(ctx, personal))
+	ok, err := store.CanViewExpression(ctx, viewer, personal)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestOrgLastOwnerAndAutoDelete(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	owner := createTestUser(t, store, "last-owner")
+	member := createTestUser(t, store, "last-member")
+	orgID := createTestOrg(t, store, "last-owner-org", owner)
+	_, err := store.AddMember(ctx, orgID, "last-member", models.OrgRoleMember)
+	require.NoError(t, err)
+
+	// единственный владелец организации с участниками не может уйти или сменить роль
+	require.ErrorIs(t, store.SetMemberRole(ctx, orgID, owner, models.OrgRoleAdmin), storage.ErrLastOwner)
+	require.ErrorIs(t, store.RemoveMember(ctx, orgID, owner), storage.ErrLastOwner)
+	role, err := store.GetMemberRole(ctx, orgID, owner)
+	require.NoError(t, err)
+	require.Equal(t, models.OrgRoleOwner, role)
+
+	// после назначения второго владельца первый может уйти
+	require.NoError(t, store.SetMemberRole(ctx, orgID, member, models.OrgRoleOwner))
+	require.NoError(t, store.SetMemberRole(ctx, orgID, owner, models.OrgRoleMember))
+	require.ErrorIs(t, store.RemoveMember(ctx, orgID, owner+member), sql.ErrNoRows)
+
+	// выражение удалённого автора остаётся в организации, пока она есть
+	authorless := createOrgExpression(t, store, owner, &orgID, "authorless-task")
+	require.NoError(t, store.DeleteUser(ctx, owner))
+	exprs, tasks, queued := expressionRows(t, testDB, authorless)
+	require.Equal(t, []int{1, 1, 1}, []int{exprs, tasks, queued})
+	kept := createOrgExpression(t, store, member, &orgID, "kept-task")
+
+	// последний участник ушёл — организация удаляется, выражение без автора вместе с ней
+	require.NoError(t, store.RemoveMember(ctx, orgID, member))
+	_, err = store.GetOrganization(ctx, orgID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	exprs, tasks, queued = expressionRows(t, testDB, authorless)
+	require.Equal(t, []int{0, 0, 0}, []int{exprs, tasks, queued})
+
+	expr, err := store.GetExpressionByID(ctx, kept)
+	require.NoError(t, err)
+	require.Nil(t, expr.OrgID, "Expression should return to its author")
+	require.Equal(t, member, expr.UserID)
+}
+
+func TestDeleteOrganizationRemovesAuthorlessExpressions(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	owner := createTestUser(t, store, "delete-owner")
+	leaver := createTestUser(t, store, "delete-leaver")
+	orgID := createTestOrg(t, store, "delete-org", owner)
+	_, err := store.AddMember(ctx, orgID, "delete-leaver", models.OrgRoleMember)
+	require.NoError(t, err)
+
+	authorless := createOrgExpression(t, store, leaver, &orgID, "deleted-author-task")
+	kept := createOrgExpression(t, store, owner, &orgID, "owner-task")
+	require.NoError(t, store.DeleteUser(ctx, leaver))
+
+	require.NoError(t, store.DeleteOrganization(ctx, orgID))
+	require.ErrorIs(t, store.DeleteOrganization(ctx, orgID), sql.ErrNoRows)
+
+	exprs, tasks, queued := expressionRows(t, testDB, authorless)
+	require.Equal(t, []int{0, 0, 0}, []int{exprs, tasks, queued}, "Authorless expression should not outlive its organization")
+
+	exprs, tasks, queued = expressionRows(t, testDB, kept)
+	require.Equal(t, []int{1, 1, 1}, []int{exprs, tasks, queued})
+	expr, err := store.GetExpressionByID(ctx, kept)
+	require.NoError(t, err)
+	require.Nil(t, expr.OrgID)
+	require.Equal(t, owner, expr.UserID)
+}
+
+// queuedSchedulingID возвращает ключ, под которым задача taskID ждёт в очереди
+func queuedSchedulingID(t *testing.T, testDB *sql.DB, taskID string) int {
+	t.Helper()
+	var id int
+	require.NoError(t, testDB.QueryRow("SELECT user_id FROM task_queue WHERE task_id = $1", taskID).Scan(&id))
+	return id
+}
+
+func TestAuthorlessTasksScheduledPerOrganization(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	owner := createTestUser(t, store, "share-owner")
+	first := createTestUser(t, store, "share-first")
+	second := createTestUser(t, store, "share-second")
+	firstOrg := createTestOrg(t, store, "share-first-org", owner)
+	secondOrg := createTestOrg(t, store, "share-second-org", owner)
+	_, err := store.AddMember(ctx, firstOrg, "share-first", models.OrgRoleMember)
+	require.NoError(t, err)
+	_, err = store.AddMember(ctx, secondOrg, "share-second", models.OrgRoleMember)
+	require.NoError(t, err)
+
+	createOrgExpression(t, store, first, &firstOrg, "first-org-1")
+	createOrgExpression(t, store, first, &firstOrg, "first-org-2")
+	createOrgExpression(t, store, second, &secondOrg, "second-org-1")
+	require.NoError(t, store.DeleteUser(ctx, first))
+	require.NoError(t, store.DeleteUser(ctx, second))
+	require.Equal(t, -firstOrg, queuedSchedulingID(t, testDB, "first-org-1"))
+	require.Equal(t, -secondOrg, queuedSchedulingID(t, testDB, "second-org-1"))
+
+	// у каждой организации своя доля: задачи без автора не делят одну общую очередь
+	require.Equal(t, []string{"first-org-1", "second-org-1", "first-org-2"}, dispatchAll(t, store))
+
+	// удаление организации убирает и её состояние планировщика
+	require.NoError(t, store.DeleteOrganization(ctx, firstOrg))
+	var rows int
+	require.NoError(t, testDB.QueryRow("SELECT COUNT(*) FROM user_scheduling WHERE user_id = $1", -firstOrg).Scan(&rows))
+	require.Zero(t, rows)
+}
+
+func TestOrganizationsRollbackKeepsAuthorlessExpressions(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	owner := createTestUser(t, store, "rollback-owner")
+	leaver := createTestUser(t, store, "rollback-leaver")
+	orgID := createTestOrg(t, store, "rollback-org", owner)
+	_, err := store.AddMember(ctx, orgID, "rollback-leaver", models.OrgRoleMember)
+	require.NoError(t, err)
+	authorless := createOrgExpression(t, store, leaver, &orgID, "rollback-task")
+	require.NoError(t, store.DeleteUser(ctx, leaver))
+	// следующие тесты ждут схему целиком
+	t.Cleanup(func() { require.NoError(t, migrateDatabase(testConnStr())) })
+
+	// без владельца выражение некому передать: откат прерывается и ничего не удаляет
+	_, err = testDB.Exec("UPDATE organization_members SET role = 'admin' WHERE org_id = $1", orgID)
+	require.NoError(t, err)
+	require.Error(t, withMigrate(testConnStr(), func(m *migrate.Migrate) error {
+		err := m.Migrate(12)
+		if err != nil {
+			// откат 000013 прошёл в одной транзакции, схема осталась прежней
+			require.NoError(t, m.Force(13))
+		}
+		return err
+	}))
+	exprs, tasks, queued := expressionRows(t, testDB, authorless)
+	require.Equal(t, []int{1, 1, 1}, []int{exprs, tasks, queued})
+
+	// с владельцем выражение и его задача в очереди переходят к нему
+	_, err = testDB.Exec("UPDATE organization_members SET role = 'owner' WHERE org_id = $1", orgID)
+	require.NoError(t, err)
+	require.NoError(t, withMigrate(testConnStr(), func(m *migrate.Migrate) error { return m.Migrate(12) }))
+	exprs, tasks, queued = expressionRows(t, testDB, authorless)
+	require.Equal(t, []int{1, 1, 1}, []int{exprs, tasks, queued})
+	var author int
+	require.NoError(t, testDB.QueryRow("SELECT user_id FROM expressions WHERE id = $1", authorless).Scan(&author))
+	require.Equal(t, owner, author)
+	require.Equal(t, owner, queuedSchedulingID(t, testDB, "rollback-task"))
+}
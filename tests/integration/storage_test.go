@@ -0,0 +1,375 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// testConnStr — строка подключения к тестовой БД; TEST_DB_CONN_STR переопределяет значение по умолчанию
+func testConnStr() string {
+	if connStr := os.Getenv("TEST_DB_CONN_STR"); connStr != "" {
+		return connStr
+	}
+	return "user=postgres dbname=test_calculator_db password=Ebds777staX sslmode=disable"
+}
+
+// migrateDatabase применяет к тестовой БД миграции из migrations/, как cmd/calculator при запуске
+func migrateDatabase(connStr string) error {
+	return withMigrate(connStr, func(m *migrate.Migrate) error {
+		if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		return nil
+	})
+}
+
+// withMigrate вызывает fn с миграциями из migrations/ для тестовой БД connStr
+func withMigrate(connStr string, fn func(m *migrate.Migrate) error) error {
+	migrationDB, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+	driver, err := postgres.WithInstance(migrationDB, &postgres.Config{})
+	if err != nil {
+		migrationDB.Close()
+		return err
+	}
+	// закрывает и migrationDB
+	m, err := migrate.NewWithDatabaseInstance("file://../../migrations", "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return err
+	}
+	defer m.Close()
+
+	return fn(m)
+}
+
+// setupStorage применяет миграции, очищает таблицы и возвращает хранилище и соединение с тестовой БД
+func setupStorage(t *testing.T) (*storage.PostgresStorage, *sql.DB) {
+	t.Helper()
+	require.NoError(t, migrateDatabase(testConnStr()))
+
+	testDB, err := sql.Open("postgres", testConnStr())
+	require.NoError(t, err)
+	t.Cleanup(func() { testDB.Close() })
+	require.NoError(t, clearDatabase(testDB))
+
+	store, err := storage.NewPostgresStorage(testConnStr())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store, testDB
+}
+
+func createTestUser(t *testing.T, store *storage.PostgresStorage, login string) int {
+	t.Helper()
+	user := &models.User{Login: login, PasswordHash: "hash"}
+	require.NoError(t, store.CreateUser(context.Background(), user))
+	return user.ID
+}
+
+// enqueueTestTask создаёт выражение из одной задачи и ставит задачу в очередь так,
+// будто она ждёт там уже age
+func enqueueTestTask(t *testing.T, store *storage.PostgresStorage, testDB *sql.DB, userID int, id string, priority int, age time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	expr := &models.Expression{UserID: userID, Expression: "1+1", Status: "pending", Priority: priority}
+	require.NoError(t, store.CreateExpression(ctx, expr))
+	require.NoError(t, store.CreateTask(ctx, &models.Task{
+		ID: id, ExpressionID: expr.ID, Arg1: "1", Arg2: "1", Operation: "+",
+		Status: "pending", DependsOn: []string{}, Priority: priority,
+	}))
+	require.NoError(t, store.AddTaskToQueue(ctx, id))
+	_, err := testDB.Exec("UPDATE task_queue SET created_at = CURRENT_TIMESTAMP - $2 * INTERVAL '1 second' WHERE task_id = $1",
+		id, age.Seconds())
+	require.NoError(t, err)
+}
+
+// dispatchAll забирает из очереди все задачи и возвращает их идентификаторы в порядке выдачи
+func dispatchAll(t *testing.T, store *storage.PostgresStorage) []string {
+	t.Helper()
+	var order []string
+	for {
+		task, err := store.GetNextTaskFromQueue(context.Background(), "test-agent", nil)
+		require.NoError(t, err)
+		if task == nil {
+			return order
+		}
+		order = append(order, task.ID)
+	}
+}
+
+func TestPriorityAging(t *testing.T) {
+	store, testDB := setupStorage(t)
+	userID := createTestUser(t, store, "aging-user")
+
+	// приоритет растёт на 1 за каждые 30 секунд ожидания:
+	// задача с приоритетом 2, прождавшая 4 минуты, обгоняет свежую задачу с приоритетом 9
+	enqueueTestTask(t, store, testDB, userID, "fresh-normal", models.DefaultPriority, 0)
+	enqueueTestTask(t, store, testDB, userID, "old-low", 2, 4*time.Minute)
+	enqueueTestTask(t, store, testDB, userID, "fresh-high", 9, 0)
+	enqueueTestTask(t, store, testDB, userID, "slightly-old-low", 2, time.Minute)
+
+	require.Equal(t, []string{"old-low", "fresh-high", "fresh-normal", "slightly-old-low"}, dispatchAll(t, store),
+		"Aged tasks should overtake fresher ones of higher priority")
+}
+
+func TestWeightedFairScheduling(t *testing.T) {
+	store, testDB := setupStorage(t)
+	light := createTestUser(t, store, "light-user")
+	heavy := createTestUser(t, store, "heavy-user")
+	require.NoError(t, store.SetUserWeight(context.Background(), heavy, 3))
+
+	for i := 0; i < 8; i++ {
+		enqueueTestTask(t, store, testDB, light, fmt.Sprintf("light-%d", i), models.DefaultPriority, 0)
+		enqueueTestTask(t, store, testDB, heavy, fmt.Sprintf("heavy-%d", i), models.DefaultPriority, 0)
+	}
+
+	// пока в очереди есть задачи обоих, пользователь с весом 3 получает втрое больше
+	order := dispatchAll(t, store)
+	require.Len(t, order, 16)
+	heavyShare := 0
+	for _, id := range order[:8] {
+		if strings.HasPrefix(id, "heavy-") {
+			heavyShare++
+		}
+	}
+	require.Equal(t, 6, heavyShare, "Dispatch order: %v", order)
+
+	// общее время планировщика не отстаёт от выданных задач
+	var systemTime float64
+	require.NoError(t, testDB.QueryRow("SELECT virtual_time FROM scheduler_state WHERE id = 1").Scan(&systemTime))
+	require.Greater(t, systemTime, 0.0)
+}
+
+func TestPriorityAcrossUsers(t *testing.T) {
+	store, testDB := setupStorage(t)
+	low := createTestUser(t, store, "low-priority-user")
+	high := createTestUser(t, store, "high-priority-user")
+	enqueueTestTask(t, store, testDB, low, "low-1", 1, 0)
+	enqueueTestTask(t, store, testDB, high, "high-1", 9, 0)
+	enqueueTestTask(t, store, testDB, high, "high-2", 9, 0)
+
+	// при равной доле первым получает задачу пользователь с более приоритетной задачей,
+	// но второй раз он не обгоняет пользователя, который ещё ничего не получил
+	require.Equal(t, []string{"high-1", "low-1", "high-2"}, dispatchAll(t, store))
+}
+
+func TestConcurrentDispatch(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	first := createTestUser(t, store, "first-user")
+	second := createTestUser(t, store, "second-user")
+	enqueueTestTask(t, store, testDB, first, "locked-task", models.DefaultPriority, 0)
+	enqueueTestTask(t, store, testDB, second, "free-task", models.DefaultPriority, 0)
+
+	// задачу первого пользователя уже забирает другая реплика: выдаётся задача второго
+	tx, err := testDB.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("SELECT task_id FROM task_queue WHERE task_id = 'locked-task' FOR UPDATE")
+	require.NoError(t, err)
+	task, err := store.GetNextTaskFromQueue(ctx, "test-agent", nil)
+	require.NoError(t, err)
+	require.NotNil(t, task, "Dispatcher should move on to the next user when the chosen user's tasks are locked")
+	require.Equal(t, "free-task", task.ID)
+	require.NoError(t, tx.Rollback())
+	require.Equal(t, []string{"locked-task"}, dispatchAll(t, store))
+
+	// одновременные выдачи разбирают всю очередь, не получая пустых ответов, пока в ней есть задачи
+	const users, perUser = 3, 4
+	for u := 0; u < users; u++ {
+		userID := createTestUser(t, store, fmt.Sprintf("concurrent-user-%d", u))
+		for i := 0; i < perUser; i++ {
+			enqueueTestTask(t, store, testDB, userID, fmt.Sprintf("concurrent-%d-%d", u, i), models.DefaultPriority, 0)
+		}
+	}
+	var wg sync.WaitGroup
+	ids := make(chan string, users*perUser)
+	for i := 0; i < users*perUser; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := store.GetNextTaskFromQueue(ctx, "test-agent", nil)
+			if err != nil {
+				t.Errorf("Dispatch failed: %v", err)
+				return
+			}
+			if task == nil {
+				t.Error("Dispatch returned no task while the queue was not empty")
+				return
+			}
+			ids <- task.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := map[string]bool{}
+	for id := range ids {
+		require.False(t, seen[id], "Task %s dispatched twice", id)
+		seen[id] = true
+	}
+	require.Len(t, seen, users*perUser)
+	require.Empty(t, queuedTaskIDs(t, testDB))
+}
+
+func TestSubmissionSlots(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "rate-user")
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := store.TakeSubmissionSlot(ctx, userID, 2)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	ok, retryAfter, err := store.TakeSubmissionSlot(ctx, userID, 2)
+	require.NoError(t, err)
+	require.False(t, ok, "Third submission in a minute should be rejected")
+	require.Greater(t, retryAfter, time.Duration(0))
+	require.LessOrEqual(t, retryAfter, time.Minute)
+
+	// отказ не расходует лимит, а возвращённая отправка освобождает место
+	require.NoError(t, store.ReleaseSubmissionSlot(ctx, userID))
+	ok, _, err = store.TakeSubmissionSlot(ctx, userID, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestMaxPendingUnderConcurrency(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "pending-user")
+
+	// одновременные отправки не должны создать больше выражений, чем разрешено держать в работе
+	const maxPending, submissions = 3, 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < submissions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expr := &models.Expression{UserID: userID, Expression: "1+1", Status: "pending", Priority: models.DefaultPriority}
+			ok, err := store.CreateExpressionWithinPending(ctx, expr, maxPending)
+			if err != nil {
+				t.Errorf("Create expression failed: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, maxPending, created)
+
+	exprs, err := store.GetUserExpressions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, exprs, maxPending)
+}
+
+func TestOperationTimePrecedence(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+
+	// значения из переменных среды (handlers.SetDefaultOperationTimes) глобальные, восстанавливаем их
+	previous := map[string]int{}
+	for _, op := range []string{"+", "-", "*", "/"} {
+		previous[op] = handlers.GetOperationTime(op)
+	}
+	t.Cleanup(func() { handlers.SetDefaultOperationTimes(previous) })
+	handlers.SetDefaultOperationTimes(map[string]int{"+": 300, "-": 1000, "*": 2000, "/": 2000})
+
+	// значение из админского API важнее переменной среды, остальные операции берутся из неё
+	require.NoError(t, store.SetOperationTimeOverrides(ctx, map[string]int{"+": 50, "*": 70}))
+	times, err := handlers.CurrentOperationTimes(ctx, store)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"+": 50, "-": 1000, "*": 70, "/": 2000}, times)
+
+	// новые задачи получают время с учётом переопределений
+	userID := createTestUser(t, store, "timing-user")
+	expr := &models.Expression{UserID: userID, Expression: "2+3-1", Status: "pending", Priority: models.DefaultPriority}
+	require.NoError(t, store.CreateExpression(ctx, expr))
+	require.NoError(t, handlers.CreateTasksFromExpression(store, expr))
+	tasks, err := store.GetTasksByExpressionID(ctx, expr.ID)
+	require.NoError(t, err)
+	byOperation := map[string]int{}
+	for _, task := range tasks {
+		byOperation[task.Operation] = task.OperationTime
+	}
+	require.Equal(t, map[string]int{"+": 50, "-": 1000}, byOperation)
+
+	// после сброса снова действуют значения из переменных среды
+	require.NoError(t, store.ResetOperationTimeOverrides(ctx))
+	times, err = handlers.CurrentOperationTimes(ctx, store)
+	require.NoError(t, err)
+	require.Equal(t, 300, times["+"])
+	require.Equal(t, 2000, times["*"])
+}
+
+func TestShutdownDrainsLongPolls(t *testing.T) {
+	store, _ := setupStorage(t)
+	ctx := context.Background()
+	keyID := createAgentKey(t, store, "drain-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "drain-agent", Operations: []string{"+"}, Concurrency: 1}, keyID))
+
+	srv := httptest.NewServer(asAgentKey(keyID, handlers.GetTaskHandler(store)))
+	t.Cleanup(srv.Close)
+
+	// агенты ждут задачу в long-poll, очередь пуста
+	const waiters = 3
+	statuses := make(chan *http.Response, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/internal/task?wait=30s", nil)
+			req.Header.Set(handlers.AgentIDHeader, "drain-agent")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Long-poll request failed: %v", err)
+				statuses <- nil
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// как в orchestrator.ShutdownServer: сначала прекращаем выдачу, затем ждём начатые запросы
+	started := time.Now()
+	store.StopDispatch()
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Config.Shutdown(shutdownCtx), "Shutdown should not wait for the long-poll timeout")
+	require.Less(t, time.Since(started), 5*time.Second)
+
+	for i := 0; i < waiters; i++ {
+		resp := <-statuses
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, "1", resp.Header.Get("Retry-After"))
+	}
+
+	// после остановки новые ожидающие не блокируются
+	_, err := store.WaitNextTaskFromQueue(ctx, 30*time.Second, "test-agent", nil)
+	require.ErrorIs(t, err, storage.ErrShuttingDown)
+}
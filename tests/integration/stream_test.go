@@ -0,0 +1,119 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// openAgentStream подключается к стриму агента agentID и объявляет concurrency
+func openAgentStream(t *testing.T, srv *httptest.Server, agentID string, concurrency int) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	header.Set(handlers.AgentIDHeader, agentID)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/internal/agent/stream", header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.WriteJSON(models.StreamMessage{Type: "hello", Concurrency: concurrency}))
+	return conn
+}
+
+func readStreamTask(t *testing.T, conn *websocket.Conn) *models.Task {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg models.StreamMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, "task", msg.Type)
+	require.NotNil(t, msg.Task)
+	return msg.Task
+}
+
+// requireNoStreamMessage проверяет, что оркестратор ничего не присылает в течение wait
+func requireNoStreamMessage(t *testing.T, conn *websocket.Conn, wait time.Duration) {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(wait)))
+	var msg models.StreamMessage
+	err := conn.ReadJSON(&msg)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "Unexpected stream message %+v (err %v)", msg, err)
+}
+
+func queuedTaskIDs(t *testing.T, testDB *sql.DB) []string {
+	t.Helper()
+	rows, err := testDB.Query("SELECT task_id FROM task_queue ORDER BY task_id")
+	require.NoError(t, err)
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+	return ids
+}
+
+func TestAgentStream(t *testing.T) {
+	store, testDB := setupStorage(t)
+	ctx := context.Background()
+	userID := createTestUser(t, store, "stream-user")
+	keyID := createAgentKey(t, store, "stream-key")
+	require.NoError(t, store.RegisterAgent(ctx, &models.Agent{ID: "stream-agent", Operations: []string{"+"}, Concurrency: 1}, keyID))
+	enqueueTestTask(t, store, testDB, userID, "stream-a", models.DefaultPriority, time.Minute)
+	enqueueTestTask(t, store, testDB, userID, "stream-b", models.DefaultPriority, 0)
+
+	srv := httptest.NewServer(asAgentKey(keyID, handlers.AgentStreamHandler(store)))
+	t.Cleanup(srv.Close)
+
+	// агент с concurrency 1 получает одну задачу, вторая ждёт в очереди
+	conn := openAgentStream(t, srv, "stream-agent", 1)
+	first := readStreamTask(t, conn)
+	require.Equal(t, "stream-a", first.ID)
+	requireNoStreamMessage(t, conn, 500*time.Millisecond)
+	require.Equal(t, []string{"stream-b"}, queuedTaskIDs(t, testDB))
+
+	// обрыв соединения возвращает невыполненную задачу в очередь
+	conn.UnderlyingConn().Close()
+	require.Eventually(t, func() bool { return len(queuedTaskIDs(t, testDB)) == 2 },
+		5*time.Second, 50*time.Millisecond, "In-flight task should be requeued after disconnect")
+
+	// failed отмечает задачу и выражение невычислимыми и освобождает место для следующей задачи
+	conn = openAgentStream(t, srv, "stream-agent", 1)
+	failed := readStreamTask(t, conn)
+	require.NoError(t, conn.WriteJSON(models.StreamMessage{Type: "result", TaskID: failed.ID, Status: "failed"}))
+	next := readStreamTask(t, conn)
+	require.NotEqual(t, failed.ID, next.ID)
+
+	var taskStatus, exprStatus string
+	require.NoError(t, testDB.QueryRow(`
+        SELECT t.status, e.status FROM tasks t JOIN expressions e ON e.id = t.expression_id
+        WHERE t.id = $1`, failed.ID).Scan(&taskStatus, &exprStatus))
+	require.Equal(t, "failed", taskStatus)
+	require.Equal(t, "failed", exprStatus)
+	require.Empty(t, queuedTaskIDs(t, testDB), "Failed task must not be requeued")
+
+	// при остановке оркестратор закрывает стрим с CloseServiceRestart и возвращает задачу в очередь
+	store.StopDispatch()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg models.StreamMessage
+	err := conn.ReadJSON(&msg)
+	require.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart), "Unexpected stream error: %v", err)
+	require.Eventually(t, func() bool {
+		ids := queuedTaskIDs(t, testDB)
+		return len(ids) == 1 && ids[0] == next.ID
+	}, 5*time.Second, 50*time.Millisecond)
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, handlers.WaitAgentStreams(waitCtx))
+}
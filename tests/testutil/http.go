@@ -0,0 +1,30 @@
+// Package testutil — общие помощники unit- и интеграционных тестов
+package testutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Serve выполняет запрос к h и возвращает записанный ответ. headers — пары «заголовок, значение»;
+// заголовки с пустым значением не передаются.
+func Serve(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i+1] != "" {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// WithValue кладёт в контекст запроса значение key, как это делают middleware, и передаёт запрос next
+func WithValue(next http.Handler, key string, value any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), key, value)))
+	})
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agent"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agentpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeAgentService реализует только unary-методы AgentService, как сервер без StreamTasks
+type fakeAgentService struct {
+	agentpb.UnimplementedAgentServiceServer
+
+	mu       sync.Mutex
+	queue    []*agentpb.Task
+	statuses map[string]string
+	results  map[string]float64
+	agentIDs []string
+	answered chan struct{}
+}
+
+func (f *fakeAgentService) GetTask(ctx context.Context, req *agentpb.GetTaskRequest) (*agentpb.GetTaskResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.mu.Lock()
+	f.agentIDs = append(f.agentIDs, md.Get("x-agent-id")...)
+	if len(f.queue) > 0 {
+		task := f.queue[0]
+		f.queue = f.queue[1:]
+		f.mu.Unlock()
+		return &agentpb.GetTaskResponse{Task: task}, nil
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(req.WaitMs) * time.Millisecond):
+	}
+	return nil, status.Error(codes.NotFound, "no tasks available")
+}
+
+func (f *fakeAgentService) SubmitResult(ctx context.Context, req *agentpb.SubmitResultRequest) (*agentpb.SubmitResultResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[req.TaskId] = req.Status
+	if req.Result != nil {
+		f.results[req.TaskId] = req.GetResult()
+	}
+	if len(f.statuses) == 2 {
+		close(f.answered)
+	}
+	return &agentpb.SubmitResultResponse{}, nil
+}
+
+func TestAgentGRPCUnaryRoundTrip(t *testing.T) {
+	service := &fakeAgentService{
+		queue: []*agentpb.Task{
+			{Id: "product", Arg1: "6", Arg2: "7", Operation: "*"},
+			{Id: "div-by-zero", Arg1: "1", Arg2: "0", Operation: "/"},
+		},
+		statuses: make(map[string]string),
+		results:  make(map[string]float64),
+		answered: make(chan struct{}),
+	}
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	agentpb.RegisterAgentServiceServer(srv, service)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	// логин и регистрация по-прежнему идут через HTTP
+	orchestrator := newFakeOrchestrator(nil)
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL,
+		agent.WithAgentID("grpc-agent"),
+		agent.WithGRPC("passthrough:///bufnet"),
+		agent.WithGRPCDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		})),
+		agent.WithPollWait(100*time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+
+	select {
+	case <-service.answered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent should fall back to GetTask/SubmitResult when StreamTasks is unimplemented")
+	}
+	ag.Stop()
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	assert.Equal(t, map[string]string{"product": "completed", "div-by-zero": "failed"}, service.statuses)
+	assert.Equal(t, 42.0, service.results["product"])
+	assert.NotEmpty(t, service.agentIDs)
+	for _, id := range service.agentIDs {
+		assert.Equal(t, "grpc-agent", id)
+	}
+}
@@ -0,0 +1,130 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamMessage — сообщение стрима /internal/agent/stream так, как его видит оркестратор
+type streamMessage struct {
+	Type        string      `json:"type"`
+	Concurrency int         `json:"concurrency,omitempty"`
+	Task        *agent.Task `json:"task,omitempty"`
+	TaskID      string      `json:"task_id,omitempty"`
+	Result      *float64    `json:"result,omitempty"`
+	Status      string      `json:"status,omitempty"`
+}
+
+// fakeStreamOrchestrator отправляет задачи агенту через стрим и собирает ответы по ним.
+// Логин и регистрацию обслуживает fakeOrchestrator; результаты зависимостей недоступны (500).
+type fakeStreamOrchestrator struct {
+	*fakeOrchestrator
+	tasks []agent.Task
+
+	mu       sync.Mutex
+	statuses map[string]string
+	answered chan struct{}
+}
+
+func newFakeStreamOrchestrator(tasks []agent.Task) *fakeStreamOrchestrator {
+	return &fakeStreamOrchestrator{
+		fakeOrchestrator: newFakeOrchestrator(nil),
+		tasks:            tasks,
+		statuses:         make(map[string]string),
+		answered:         make(chan struct{}),
+	}
+}
+
+func (f *fakeStreamOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/internal/agent/stream":
+		f.serveStream(w, r)
+	case strings.HasPrefix(r.URL.Path, "/internal/task/"):
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+	default:
+		f.fakeOrchestrator.ServeHTTP(w, r)
+	}
+}
+
+func (f *fakeStreamOrchestrator) serveStream(w http.ResponseWriter, r *http.Request) {
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var hello streamMessage
+	if err := conn.ReadJSON(&hello); err != nil || hello.Type != "hello" {
+		return
+	}
+	for i := range f.tasks {
+		if err := conn.WriteJSON(streamMessage{Type: "task", Task: &f.tasks[i]}); err != nil {
+			return
+		}
+	}
+
+	for {
+		var msg streamMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type != "result" {
+			continue
+		}
+		f.mu.Lock()
+		f.statuses[msg.TaskID] = msg.Status
+		if msg.Status == "completed" && msg.Result != nil {
+			f.results[msg.TaskID] = *msg.Result
+		}
+		if len(f.statuses) == len(f.tasks) {
+			close(f.answered)
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestAgentStreamFailsUncomputableTasks(t *testing.T) {
+	orchestrator := newFakeStreamOrchestrator([]agent.Task{
+		{ID: "sum", Arg1: "2", Arg2: "3", Operation: "+"},
+		{ID: "div-by-zero", Arg1: "1", Arg2: "0", Operation: "/"},
+		{ID: "unknown-op", Arg1: "1", Arg2: "2", Operation: "^"},
+		{ID: "bad-arg", Arg1: "two", Arg2: "2", Operation: "+"},
+		// результат зависимости получить не удаётся — это временная ошибка
+		{ID: "dependent", Arg1: "3f2504e0-4f89-11d3-9a0c-0305e82c3301", Arg2: "1", Operation: "+"},
+	})
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL,
+		agent.WithComputingPower(5),
+		agent.WithPollInterval(50*time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+	defer ag.Stop()
+
+	select {
+	case <-orchestrator.answered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent should answer every streamed task without a long back-off")
+	}
+
+	orchestrator.mu.Lock()
+	defer orchestrator.mu.Unlock()
+	assert.Equal(t, map[string]string{
+		"sum":         "completed",
+		"div-by-zero": "failed",
+		"unknown-op":  "failed",
+		"bad-arg":     "failed",
+		"dependent":   "pending",
+	}, orchestrator.statuses, "Only transient errors should send a task back to the queue")
+	assert.Equal(t, 5.0, orchestrator.results["sum"])
+}
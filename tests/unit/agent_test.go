@@ -0,0 +1,320 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAgentKey = "calc_agent_test"
+
+// fakeOrchestrator отдаёт задачи через long-poll /internal/task и собирает результаты.
+// Стрим не поддерживается, поэтому агент работает в режиме опроса.
+type fakeOrchestrator struct {
+	mu       sync.Mutex
+	queue    []agent.Task
+	results  map[string]float64
+	returned []string
+	failed   []string
+	logins   int
+	taken    chan string
+	done     chan struct{}
+
+	// registrations — тела запросов POST /internal/agents, agentIDs — заголовки X-Agent-ID запросов за задачами
+	registrations []map[string]interface{}
+	agentIDs      []string
+	// сколько раз ответить на запрос задачи 409, будто агент не зарегистрирован
+	forgetAgent int
+	// срок жизни выдаваемых токенов в секундах; 0 — не сообщать
+	tokenTTL int
+}
+
+func newFakeOrchestrator(tasks []agent.Task) *fakeOrchestrator {
+	return &fakeOrchestrator{
+		queue:   tasks,
+		results: make(map[string]float64),
+		taken:   make(chan string, len(tasks)),
+		done:    make(chan struct{}),
+	}
+}
+
+func (f *fakeOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/internal/agent/token":
+		if r.Header.Get("Authorization") != "ApiKey "+testAgentKey {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.logins++
+		token := fmt.Sprintf("test-token-%d", f.logins)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "expires_in": f.tokenTTL})
+	case "/internal/agents":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.registrations = append(f.registrations, body)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(body)
+	case "/internal/task":
+		f.mu.Lock()
+		f.agentIDs = append(f.agentIDs, r.Header.Get("X-Agent-ID"))
+		if f.forgetAgent > 0 {
+			f.forgetAgent--
+			f.mu.Unlock()
+			http.Error(w, "Agent is not registered", http.StatusConflict)
+			return
+		}
+		if len(f.queue) == 0 {
+			f.mu.Unlock()
+			// пустая очередь: держим запрос, пока агент не отменит его
+			<-r.Context().Done()
+			return
+		}
+		task := f.queue[0]
+		f.queue = f.queue[1:]
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(task)
+		f.taken <- task.ID
+	case "/internal/task/requeue":
+		var body struct {
+			ID     string   `json:"id"`
+			Result *float64 `json:"result"`
+			Status string   `json:"status"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		if body.Status == "pending" {
+			f.returned = append(f.returned, body.ID)
+		} else if body.Status == "failed" {
+			f.failed = append(f.failed, body.ID)
+		} else if body.Result != nil {
+			f.results[body.ID] = *body.Result
+			if len(f.results) == cap(f.taken) {
+				close(f.done)
+			}
+		}
+		f.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAgentWorkersProcessTasksConcurrently(t *testing.T) {
+	var tasks []agent.Task
+	for i := 0; i < 4; i++ {
+		tasks = append(tasks, agent.Task{
+			ID:            fmt.Sprintf("task-%d", i),
+			Arg1:          fmt.Sprint(i),
+			Arg2:          "10",
+			Operation:     "*",
+			OperationTime: 500,
+		})
+	}
+	orchestrator := newFakeOrchestrator(tasks)
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL, agent.WithComputingPower(4))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+
+	select {
+	case <-orchestrator.done:
+	case <-time.After(1500 * time.Millisecond):
+		t.Fatal("4 workers should finish 4 tasks of 500ms well before they would sequentially")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		ag.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop should cancel pending long-polls and return")
+	}
+
+	orchestrator.mu.Lock()
+	defer orchestrator.mu.Unlock()
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, float64(i*10), orchestrator.results[fmt.Sprintf("task-%d", i)])
+	}
+	assert.Equal(t, 1, orchestrator.logins, "Workers should share one token")
+}
+
+func TestAgentStopReturnsUnfinishedTasks(t *testing.T) {
+	orchestrator := newFakeOrchestrator([]agent.Task{
+		{ID: "slow-task", Arg1: "2", Arg2: "3", Operation: "+", OperationTime: 10000},
+	})
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL,
+		agent.WithComputingPower(2),
+		agent.WithShutdownTimeout(200*time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+
+	select {
+	case <-orchestrator.taken:
+	case <-time.After(time.Second):
+		t.Fatal("Agent should take the task")
+	}
+	// даём агенту прочитать ответ и начать вычисление
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	ag.Stop()
+	assert.Less(t, time.Since(start), 2*time.Second, "Stop should not wait for the task longer than the shutdown timeout")
+
+	orchestrator.mu.Lock()
+	defer orchestrator.mu.Unlock()
+	assert.Equal(t, []string{"slow-task"}, orchestrator.returned, "Unfinished task should be returned to the queue")
+	assert.Empty(t, orchestrator.results)
+}
+
+func TestAgentPollingFailsUncomputableTasks(t *testing.T) {
+	orchestrator := newFakeOrchestrator([]agent.Task{
+		{ID: "div-by-zero", Arg1: "1", Arg2: "0", Operation: "/"},
+		{ID: "sum", Arg1: "2", Arg2: "3", Operation: "+"},
+	})
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL, agent.WithComputingPower(1))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+
+	assert.Eventually(t, func() bool {
+		orchestrator.mu.Lock()
+		defer orchestrator.mu.Unlock()
+		return len(orchestrator.results) == 1
+	}, 2*time.Second, 10*time.Millisecond, "Agent should report the failed task and move on to the next one")
+	ag.Stop()
+
+	orchestrator.mu.Lock()
+	defer orchestrator.mu.Unlock()
+	assert.Equal(t, []string{"div-by-zero"}, orchestrator.failed)
+	assert.Empty(t, orchestrator.returned, "Uncomputable task should not go back to the queue")
+	assert.Equal(t, 5.0, orchestrator.results["sum"])
+}
+
+func TestAgentRegistersWithCapabilities(t *testing.T) {
+	orchestrator := newFakeOrchestrator([]agent.Task{
+		{ID: "sub-task", Arg1: "10", Arg2: "4", Operation: "-"},
+	})
+	orchestrator.forgetAgent = 1
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	ag, err := agent.NewAgent(testAgentKey, server.URL,
+		agent.WithAgentID("agent-1"),
+		agent.WithOperations("+", "-"),
+		agent.WithComputingPower(2))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+
+	select {
+	case <-orchestrator.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent should re-register and compute the task")
+	}
+	ag.Stop()
+
+	orchestrator.mu.Lock()
+	defer orchestrator.mu.Unlock()
+	assert.Equal(t, 6.0, orchestrator.results["sub-task"])
+	assert.Len(t, orchestrator.registrations, 2, "Agent should register on start and again after 409")
+	registration := orchestrator.registrations[0]
+	assert.Equal(t, "agent-1", registration["id"])
+	assert.Equal(t, []interface{}{"+", "-"}, registration["operations"])
+	assert.Equal(t, 2.0, registration["concurrency"])
+	for _, id := range orchestrator.agentIDs {
+		assert.Equal(t, "agent-1", id)
+	}
+}
+
+// fakeClock — часы, которые идут только по Advance
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeTimer{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance переводит часы вперёд и срабатывает таймеры, чей срок наступил
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func TestAgentRefreshesTokenBeforeExpiry(t *testing.T) {
+	orchestrator := newFakeOrchestrator(nil)
+	orchestrator.tokenTTL = 10
+	server := httptest.NewServer(orchestrator)
+	defer server.Close()
+
+	clock := &fakeClock{now: time.Now()}
+	ag, err := agent.NewAgent(testAgentKey, server.URL, agent.WithClock(clock))
+	assert.NoError(t, err)
+	assert.NoError(t, ag.Start())
+	defer ag.Stop()
+
+	logins := func() int {
+		orchestrator.mu.Lock()
+		defer orchestrator.mu.Unlock()
+		return orchestrator.logins
+	}
+	assert.Eventually(t, func() bool { return clock.Waiting() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// токен на 10 секунд обновляется на 80% срока, не раньше
+	clock.Advance(7 * time.Second)
+	assert.Never(t, func() bool { return logins() > 1 }, 100*time.Millisecond, 10*time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return logins() == 2 }, 5*time.Second, 10*time.Millisecond,
+		"Agent should refresh the token proactively")
+}
@@ -1,16 +1,22 @@
 package unit
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/models"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGenerateAndParseToken(t *testing.T) {
 	userID := 123
-	token, err := auth.GenerateToken(userID)
+	token, err := auth.GenerateToken(userID, auth.RoleUser, 0)
 	assert.NoError(t, err, "GenerateToken should not return an error")
 	assert.NotEmpty(t, token, "Generated token should not be empty")
 
@@ -31,7 +37,7 @@ func TestParseTokenInvalid(t *testing.T) {
 
 func TestParseTokenWithBearer(t *testing.T) {
 	userID := 456
-	token, err := auth.GenerateToken(userID)
+	token, err := auth.GenerateToken(userID, auth.RoleUser, 0)
 	assert.NoError(t, err)
 
 	bearerToken := "Bearer " + token
@@ -39,3 +45,229 @@ func TestParseTokenWithBearer(t *testing.T) {
 	assert.NoError(t, err, "ParseToken should handle Bearer prefix")
 	assert.Equal(t, userID, claims.UserID, "Parsed user ID should match with Bearer prefix")
 }
+
+func TestUserTokensAreRevocable(t *testing.T) {
+	first, err := auth.GenerateToken(1, auth.RoleUser, 0)
+	assert.NoError(t, err)
+	second, err := auth.GenerateToken(1, auth.RoleUser, 3)
+	assert.NoError(t, err)
+
+	a, err := auth.ParseToken(first)
+	assert.NoError(t, err)
+	b, err := auth.ParseToken(second)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, a.ID, "User token should carry a jti")
+	assert.NotEqual(t, a.ID, b.ID, "Every token should get its own jti")
+	if assert.NotNil(t, a.IssuedAt, "User token should carry iat") {
+		assert.WithinDuration(t, time.Now(), a.IssuedAt.Time, time.Minute)
+	}
+	assert.Equal(t, 0, a.SessionVersion)
+	assert.Equal(t, 3, b.SessionVersion, "Token should carry the session version it was issued with")
+}
+
+func TestTokenRoles(t *testing.T) {
+	token, err := auth.GenerateToken(1, auth.RoleAdmin, 0)
+	assert.NoError(t, err)
+	claims, err := auth.ParseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, claims.UserRole())
+	assert.False(t, claims.IsAgent())
+
+	agentToken, err := auth.GenerateAgentToken(7)
+	assert.NoError(t, err)
+	claims, err = auth.ParseToken(agentToken)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleAgent, claims.UserRole())
+
+	legacy := &auth.Claims{UserID: 1}
+	assert.Equal(t, auth.RoleUser, legacy.UserRole(), "Tokens without a role should act as plain users")
+
+	assert.True(t, auth.IsUserRole(auth.RoleAdmin))
+	assert.False(t, auth.IsUserRole(auth.RoleAgent), "Users cannot be given the agent role")
+}
+
+func TestAgentTokenPrincipal(t *testing.T) {
+	token, err := auth.GenerateAgentToken(7)
+	assert.NoError(t, err)
+
+	claims, err := auth.ParseToken(token)
+	assert.NoError(t, err)
+	assert.True(t, claims.IsAgent(), "Agent token should carry the agent principal")
+	assert.Equal(t, 7, claims.AgentKeyID)
+	assert.Zero(t, claims.UserID, "Agent token should not act as a user")
+
+	userToken, err := auth.GenerateToken(123, auth.RoleUser, 0)
+	assert.NoError(t, err)
+	userClaims, err := auth.ParseToken(userToken)
+	assert.NoError(t, err)
+	assert.False(t, userClaims.IsAgent(), "User token should not be accepted as an agent")
+}
+
+func TestAPIKey(t *testing.T) {
+	key, err := auth.NewAPIKey(auth.AgentKeyPrefix)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, auth.AgentKeyPrefix))
+
+	other, err := auth.NewAPIKey(auth.AgentKeyPrefix)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, auth.HashAPIKey(key), auth.HashAPIKey(other))
+	assert.Equal(t, auth.HashAPIKey(key), auth.HashAPIKey(key))
+
+	parsed, ok := auth.APIKeyFromHeader("ApiKey " + key)
+	assert.True(t, ok)
+	assert.Equal(t, key, parsed)
+	_, ok = auth.APIKeyFromHeader("Bearer " + key)
+	assert.False(t, ok)
+
+	userKey, err := auth.NewAPIKey(auth.UserKeyPrefix)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(auth.APIKeyDisplayPrefix(userKey), auth.UserKeyPrefix),
+		"Display prefix should tell user keys from agent keys")
+	assert.True(t, auth.IsScope(auth.ScopeRead))
+	assert.True(t, auth.IsScope(auth.ScopeSubmit))
+	assert.False(t, auth.IsScope("admin"))
+}
+
+func TestKeyRotation(t *testing.T) {
+	defer auth.SetSecretKey("secret-key")
+
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	file, err := auth.ReadKeyFile(path)
+	assert.NoError(t, err, "Missing key file should be treated as empty")
+	oldKey, err := file.Generate(auth.AlgHS256)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Write(path))
+
+	ks, err := auth.LoadKeySet(path, "legacy-secret-0123")
+	assert.NoError(t, err)
+	assert.Equal(t, oldKey.ID, ks.SigningKeyID(), "Key from the file should sign instead of the legacy secret")
+
+	auth.SetSecretKey("legacy-secret-0123")
+	legacyToken, err := auth.GenerateToken(1, auth.RoleUser, 0)
+	assert.NoError(t, err)
+	auth.SetKeySet(ks)
+	oldToken, err := auth.GenerateToken(2, auth.RoleUser, 0)
+	assert.NoError(t, err)
+
+	file, err = auth.ReadKeyFile(path)
+	assert.NoError(t, err)
+	newKey, err := file.Generate(auth.AlgHS256)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Write(path))
+	ks, err = auth.LoadKeySet(path, "legacy-secret-0123")
+	assert.NoError(t, err)
+	auth.SetKeySet(ks)
+	assert.Equal(t, newKey.ID, ks.SigningKeyID(), "Newest key should sign new tokens")
+
+	_, err = auth.ParseToken(legacyToken)
+	assert.NoError(t, err, "Tokens without kid should be accepted while the legacy secret is configured")
+	claims, err := auth.ParseToken(oldToken)
+	assert.NoError(t, err, "Tokens signed with the previous key should survive rotation")
+	assert.Equal(t, 2, claims.UserID)
+
+	_, err = file.Retire(oldKey.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Write(path))
+	ks, err = auth.LoadKeySet(path, "")
+	assert.NoError(t, err)
+	auth.SetKeySet(ks)
+	_, err = auth.ParseToken(oldToken)
+	assert.Error(t, err, "Tokens signed with a retired key should be rejected")
+	_, err = auth.ParseToken(legacyToken)
+	assert.Error(t, err, "Tokens without kid should be rejected once the legacy secret is removed")
+
+	_, err = file.Retire(newKey.ID)
+	assert.Error(t, err, "The only key should not be retired")
+}
+
+func TestAsymmetricKeysAndJWKS(t *testing.T) {
+	defer auth.SetSecretKey("secret-key")
+
+	for _, alg := range []string{auth.AlgRS256, auth.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			file, err := auth.ReadKeyFile(path)
+			assert.NoError(t, err)
+			kc, err := file.Generate(alg)
+			assert.NoError(t, err)
+			assert.NoError(t, file.Write(path))
+
+			ks, err := auth.LoadKeySet(path, "legacy-secret-0123")
+			assert.NoError(t, err)
+			auth.SetKeySet(ks)
+			token, err := auth.GenerateToken(42, auth.RoleUser, 0)
+			assert.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+			assert.NoError(t, err)
+			assert.Equal(t, alg, parsed.Header["alg"])
+			assert.Equal(t, kc.ID, parsed.Header["kid"])
+
+			jwks := auth.CurrentJWKS()
+			assert.Len(t, jwks.Keys, 1, "Only public keys should be published, never HMAC secrets")
+			assert.Equal(t, kc.ID, jwks.Keys[0].KeyID)
+
+			// другой сервис проверяет токен, зная только JWKS
+			data, err := json.Marshal(jwks)
+			assert.NoError(t, err)
+			verifier, err := auth.KeySetFromJWKS(data)
+			assert.NoError(t, err)
+			claims, err := verifier.ParseToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, 42, claims.UserID)
+
+			// токен с kid асимметричного ключа, но подписанный как HS256, не принимается
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{UserID: 1})
+			forged.Header["kid"] = kc.ID
+			forgedString, err := forged.SignedString(data)
+			assert.NoError(t, err)
+			_, err = auth.ParseToken(forgedString)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(list, []byte("# top passwords\nqwerty123\n\nPassword1\n"), 0o600))
+
+	policy, err := auth.NewPasswordPolicy(8, list)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, policy.BreachedCount())
+
+	assert.NoError(t, policy.Check("alice", "correct horse battery"))
+	assert.ErrorIs(t, policy.Check("alice", "short"), auth.ErrPasswordTooShort)
+	assert.ErrorIs(t, policy.Check("alice", strings.Repeat("a", auth.MaxPasswordLength+1)), auth.ErrPasswordTooLong)
+	// минимум — в символах, а не в байтах: 8 кириллических букв занимают 16 байт
+	assert.NoError(t, policy.Check("alice", "пароль12"))
+	assert.ErrorIs(t, policy.Check("alice", "пароль1"), auth.ErrPasswordTooShort)
+	assert.ErrorIs(t, policy.Check("alice", strings.Repeat("я", auth.MaxPasswordLength/2+1)), auth.ErrPasswordTooLong)
+	assert.ErrorIs(t, policy.Check("alice123", "ALICE123"), auth.ErrPasswordMatchesLogin)
+	assert.ErrorIs(t, policy.Check("alice", "password1"), auth.ErrPasswordBreached, "Breached list should be case-insensitive")
+
+	_, err = auth.NewPasswordPolicy(0, "")
+	assert.Error(t, err)
+	_, err = auth.NewPasswordPolicy(8, filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err, "Missing breached list should be reported")
+}
+
+func TestLoginProtectionDelay(t *testing.T) {
+	p := models.LoginProtection{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Zero(t, p.Delay(3, 3), "Free attempts should not be delayed")
+	assert.Equal(t, time.Second, p.Delay(4, 3))
+	assert.Equal(t, 2*time.Second, p.Delay(5, 3))
+	assert.Equal(t, 8*time.Second, p.Delay(7, 3))
+	assert.Equal(t, 10*time.Second, p.Delay(8, 3), "Backoff should be capped by MaxDelay")
+	assert.Equal(t, 10*time.Second, p.Delay(1000, 3))
+}
+
+func TestOrgRoles(t *testing.T) {
+	assert.True(t, models.OrgRoleAtLeast(models.OrgRoleOwner, models.OrgRoleAdmin))
+	assert.True(t, models.OrgRoleAtLeast(models.OrgRoleMember, models.OrgRoleMember))
+	assert.False(t, models.OrgRoleAtLeast(models.OrgRoleViewer, models.OrgRoleMember), "Viewers cannot submit expressions")
+	assert.False(t, models.OrgRoleAtLeast("", models.OrgRoleViewer), "Non-members have no rights")
+	assert.False(t, models.IsOrgRole(auth.RoleUser))
+}
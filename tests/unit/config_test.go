@@ -0,0 +1,121 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func envFromMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadAgentPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	err := os.WriteFile(path, []byte(`
+server_url: https://calc.example.com
+agent_id: worker-1
+api_key: from-file
+computing_power: 2
+poll_wait: 15s
+log_level: debug
+`), 0o600)
+	assert.NoError(t, err)
+
+	env := envFromMap(map[string]string{
+		"AGENT_CONFIG":    path,
+		"COMPUTING_POWER": "4",
+		"AGENT_API_KEY":   "from-env",
+	})
+	cfg, printConfig, err := config.LoadAgent([]string{"-computing-power=8"}, env)
+	assert.NoError(t, err)
+	assert.False(t, printConfig)
+
+	assert.Equal(t, "https://calc.example.com", cfg.ServerURL, "File should override defaults")
+	assert.Equal(t, "worker-1", cfg.AgentID)
+	assert.Equal(t, "from-env", cfg.APIKey, "Env should override file")
+	assert.Equal(t, 8, cfg.ComputingPower, "Flag should override env")
+	assert.Equal(t, config.Duration(15*time.Second), cfg.PollWait)
+	assert.Equal(t, config.Duration(5*time.Second), cfg.PollInterval, "Unset values should keep defaults")
+	assert.Equal(t, "debug", cfg.LogLevel)
+}
+
+func TestLoadAgentJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	err := os.WriteFile(path, []byte(`{"api_key": "key", "transport": "grpc", "grpc_addr": "calc:9090"}`), 0o600)
+	assert.NoError(t, err)
+
+	cfg, _, err := config.LoadAgent([]string{"-config", path}, envFromMap(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", cfg.APIKey)
+	assert.Equal(t, "grpc", cfg.Transport)
+	assert.Equal(t, "calc:9090", cfg.GRPCAddr)
+}
+
+func TestLoadAgentValidation(t *testing.T) {
+	_, _, err := config.LoadAgent([]string{"-server-url=localhost", "-computing-power=0", "-log-level=loud"}, envFromMap(nil))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "server_url")
+	assert.Contains(t, err.Error(), "api_key", "Agent should not start without an API key")
+	assert.Contains(t, err.Error(), "computing_power")
+	assert.Contains(t, err.Error(), "log level")
+
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("computing_pwr: 3\n"), 0o600))
+	_, _, err = config.LoadAgent([]string{"-config", path}, envFromMap(nil))
+	assert.Error(t, err, "Unknown keys in config file should be rejected")
+}
+
+func TestAgentConfigMasked(t *testing.T) {
+	cfg, printConfig, err := config.LoadAgent([]string{"-print-config", "-api-key=secret-key"}, envFromMap(nil))
+	assert.NoError(t, err)
+	assert.True(t, printConfig)
+
+	masked := cfg.Masked()
+	assert.NotContains(t, masked.APIKey, "secret")
+	assert.Equal(t, "secret-key", cfg.APIKey, "Masking should not change the original config")
+}
+
+func TestLoadOrchestrator(t *testing.T) {
+	dsnFile := filepath.Join(t.TempDir(), "dsn")
+	assert.NoError(t, os.WriteFile(dsnFile, []byte("user=calc password=s3cret dbname=calc sslmode=disable\n"), 0o600))
+
+	env := envFromMap(map[string]string{
+		"DB_CONN_STR_FILE":        dsnFile,
+		"JWT_SECRET":              "0123456789abcdef",
+		"TIME_MULTIPLICATION_MS":  "300",
+		"TIME_MULTIPLICATIONS_MS": "400",
+		"FEATURE_GRPC":            "false",
+	})
+	cfg, _, err := config.LoadOrchestrator([]string{"-listen-addr=:9000"}, env)
+	assert.NoError(t, err)
+
+	assert.Equal(t, ":9000", cfg.ListenAddr)
+	assert.Equal(t, "user=calc password=s3cret dbname=calc sslmode=disable", cfg.Database.DSN, "DSN should be read from file")
+	assert.Equal(t, 400, cfg.OperationTimes.Multiplication, "New variable name should win over the old one")
+	assert.False(t, cfg.Features.GRPC)
+
+	masked := cfg.Masked()
+	assert.NotContains(t, masked.Database.DSN, "s3cret")
+	assert.Contains(t, masked.Database.DSN, "user=calc")
+	assert.NotContains(t, masked.JWTSecret, "0123")
+}
+
+func TestLoadOrchestratorValidation(t *testing.T) {
+	env := envFromMap(map[string]string{
+		"JWT_SECRET":         "short",
+		"HTTP_WRITE_TIMEOUT": "10s",
+	})
+	_, _, err := config.LoadOrchestrator(nil, env)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dsn is required")
+	assert.Contains(t, err.Error(), "jwt_secret")
+	assert.Contains(t, err.Error(), "write_timeout", "Write timeout shorter than the task long-poll should be rejected")
+}
@@ -0,0 +1,43 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/handlers"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// Регистрация отклоняется до обращения к БД, поэтому хранилище не нужно
+func TestRegisterHandlerRejectsInvalidInput(t *testing.T) {
+	policy, err := auth.NewPasswordPolicy(8, "")
+	assert.NoError(t, err)
+	h := handlers.RegisterHandler(nil, policy)
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"too short login", `{"login":"ab","password":"correct horse battery"}`, handlers.CodeInvalidLogin},
+		{"login with spaces", `{"login":"bad login","password":"correct horse battery"}`, handlers.CodeInvalidLogin},
+		{"login starting with a dot", `{"login":".alice","password":"correct horse battery"}`, handlers.CodeInvalidLogin},
+		{"short password", `{"login":"alice","password":"short"}`, handlers.CodeWeakPassword},
+		{"password equal to login", `{"login":"alice123","password":"ALICE123"}`, handlers.CodeWeakPassword},
+		{"too long password", `{"login":"alice","password":"` + strings.Repeat("a", auth.MaxPasswordLength+1) + `"}`, handlers.CodeWeakPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := testutil.Serve(h, http.MethodPost, "/api/v1/register", tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			var resp handlers.ErrorResponse
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tt.code, resp.Code)
+		})
+	}
+}
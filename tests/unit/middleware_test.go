@@ -0,0 +1,108 @@
+package unit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/auth"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/middleware"
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/tests/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// reachedHandler отмечает, что запрос прошёл через middleware
+func reachedHandler(reached *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*reached = true
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Токены отклоняются до обращения к БД, поэтому хранилище не нужно
+func TestUserTokenRejectedOnInternalAPI(t *testing.T) {
+	token, err := auth.GenerateToken(1, auth.RoleAdmin, 0)
+	assert.NoError(t, err)
+
+	reached := false
+	h := middleware.AgentAuthMiddleware(nil, reachedHandler(&reached))
+
+	rec := testutil.Serve(h, http.MethodGet, "/internal/task", "", "Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = testutil.Serve(h, http.MethodGet, "/internal/task", "", "Authorization", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = testutil.Serve(h, http.MethodGet, "/internal/task", "", "Authorization", "Bearer invalid.token.here")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.False(t, reached, "User tokens must not reach agent endpoints")
+}
+
+func TestAgentTokenRejectedOnUserAPI(t *testing.T) {
+	token, err := auth.GenerateAgentToken(1)
+	assert.NoError(t, err)
+
+	reached := false
+	h := middleware.AuthMiddleware(nil, reachedHandler(&reached))
+
+	rec := testutil.Serve(h, http.MethodPost, "/api/v1/calculate", "", "Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = testutil.Serve(h, http.MethodGet, "/api/v1/expressions", "", "Authorization", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.False(t, reached, "Agent tokens must not reach user endpoints")
+}
+
+func TestRoleMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		role interface{}
+		want int
+	}{
+		{"admin passes", auth.RoleAdmin, http.StatusOK},
+		{"user is forbidden", auth.RoleUser, http.StatusForbidden},
+		{"agent is forbidden", auth.RoleAgent, http.StatusForbidden},
+		{"no role is forbidden", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := middleware.RoleMiddleware(reachedHandler(&reached), auth.RoleAdmin)
+
+			if tt.role != nil {
+				h = testutil.WithValue(h, "role", tt.role)
+			}
+			rec := testutil.Serve(h, http.MethodGet, "/api/v1/admin/users", "")
+
+			assert.Equal(t, tt.want, rec.Code)
+			assert.Equal(t, tt.want == http.StatusOK, reached)
+		})
+	}
+}
+
+func TestAdminMiddlewareToken(t *testing.T) {
+	agentToken, err := auth.GenerateAgentToken(1)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		configured string
+		header     string
+		auth       string
+		want       int
+	}{
+		{"valid admin token passes", "s3cret", "s3cret", "", http.StatusOK},
+		{"wrong admin token", "s3cret", "guess", "", http.StatusUnauthorized},
+		{"admin token disabled", "", "s3cret", "", http.StatusForbidden},
+		{"no credentials", "s3cret", "", "", http.StatusUnauthorized},
+		{"agent token is not an admin", "s3cret", "", "Bearer " + agentToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := middleware.AdminMiddleware(tt.configured, nil, reachedHandler(&reached))
+
+			rec := testutil.Serve(h, http.MethodGet, "/api/v1/admin/users", "",
+				"X-Admin-Token", tt.header, "Authorization", tt.auth)
+
+			assert.Equal(t, tt.want, rec.Code)
+			assert.Equal(t, tt.want == http.StatusOK, reached)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtrmalay/LMS.Sprint1.HTTP-Calculator/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskNotifierWakesWaiters(t *testing.T) {
+	n := storage.NewTaskNotifier()
+
+	first := n.Wait()
+	second := n.Wait()
+
+	select {
+	case <-first:
+		t.Fatal("Wait channel should block before Notify")
+	default:
+	}
+
+	n.Notify()
+
+	for _, ch := range []<-chan struct{}{first, second} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Notify should wake every waiter")
+		}
+	}
+
+	select {
+	case <-n.Wait():
+		t.Fatal("Wait after Notify should return a fresh channel")
+	default:
+	}
+}
+
+func TestTaskNotifierNotifyWithoutWaiters(t *testing.T) {
+	n := storage.NewTaskNotifier()
+	assert.NotPanics(t, func() {
+		n.Notify()
+		n.Notify()
+	}, "Notify without waiters should not panic")
+}
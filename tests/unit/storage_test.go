@@ -19,7 +19,7 @@ func setupTestDB(t *testing.T) (*storage.PostgresStorage, func()) {
 
 	// Очищаем таблицы перед тестом
 	_, err = db.Exec(`
-		DROP TABLE IF EXISTS task_queue, tasks, expressions, users CASCADE;
+		DROP TABLE IF EXISTS scheduler_state, user_scheduling, task_queue, tasks, expressions, users CASCADE;
 		CREATE TABLE users (
 			id SERIAL PRIMARY KEY,
 			login TEXT UNIQUE NOT NULL,
@@ -32,6 +32,7 @@ func setupTestDB(t *testing.T) (*storage.PostgresStorage, func()) {
 			expression TEXT NOT NULL,
 			result DOUBLE PRECISION,
 			status TEXT NOT NULL,
+			priority SMALLINT NOT NULL DEFAULT 5,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);
 		CREATE TABLE tasks (
@@ -43,11 +44,26 @@ func setupTestDB(t *testing.T) (*storage.PostgresStorage, func()) {
 			operation_time INTEGER NOT NULL,
 			status TEXT NOT NULL,
 			result DOUBLE PRECISION,
-			depends_on TEXT[]
+			depends_on TEXT[],
+			priority SMALLINT NOT NULL DEFAULT 5
 		);
 		CREATE TABLE task_queue (
-			task_id TEXT PRIMARY KEY REFERENCES tasks(id)
+			task_id TEXT PRIMARY KEY REFERENCES tasks(id),
+			priority SMALLINT NOT NULL DEFAULT 5,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE user_scheduling (
+			user_id INTEGER PRIMARY KEY,
+			weight INTEGER NOT NULL DEFAULT 1,
+			virtual_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+			dispatched BIGINT NOT NULL DEFAULT 0
+		);
+		CREATE TABLE scheduler_state (
+			id SMALLINT PRIMARY KEY,
+			virtual_time DOUBLE PRECISION NOT NULL DEFAULT 0
 		);
+		INSERT INTO scheduler_state (id) VALUES (1);
 	`)
 	assert.NoError(t, err, "Failed to create tables")
 
@@ -55,7 +71,7 @@ func setupTestDB(t *testing.T) (*storage.PostgresStorage, func()) {
 	assert.NoError(t, err, "Failed to initialize storage")
 
 	cleanup := func() {
-		_, _ = db.Exec(`DROP TABLE IF EXISTS task_queue, tasks, expressions, users CASCADE;`)
+		_, _ = db.Exec(`DROP TABLE IF EXISTS scheduler_state, user_scheduling, task_queue, tasks, expressions, users CASCADE;`)
 		store.Close()
 	}
 